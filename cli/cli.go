@@ -2,6 +2,7 @@ package cli
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -44,18 +45,26 @@ type TaskStatus struct {
 	CreatedAt string     `json:"created_at"`
 }
 
-func StartGUI() error {
+// StartGUI runs the terminal interface until the user quits or ctx is
+// cancelled.
+func StartGUI(ctx context.Context) error {
 	g, err := gocui.NewGui(gocui.OutputNormal, false)
 	if err != nil {
 		return err
 	}
 	defer g.Close()
 
+	stop := context.AfterFunc(ctx, func() {
+		g.UpdateAsync(func(*gocui.Gui) error { return gocui.ErrQuit })
+	})
+	defer stop()
+
 	state := &GUIState{
 		g:           g,
 		OutputLines: []string{"Welcome :)", "Press 'c' to create new task", "Press 'a' to add file to task", "Press 's' to show task status", "Press 'd' to download archive"},
 	}
 
+	state.loadTasks()
 	g.SetManagerFunc(state.layout)
 
 	if err := state.keybindings(); err != nil {
@@ -315,8 +324,24 @@ func (s *GUIState) addOutput(line string) {
 	})
 }
 
+// apiRequest calls the local API, authenticating with ARCHIVE_API_KEY when
+// the server requires keys.
+func apiRequest(method, target string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key := os.Getenv("ARCHIVE_API_KEY"); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	return http.DefaultClient.Do(req)
+}
+
 func (s *GUIState) createTask(g *gocui.Gui, v *gocui.View) error {
-	resp, err := http.Post("http://localhost:8080/tasks", "application/json", nil)
+	resp, err := apiRequest(http.MethodPost, "http://localhost:8080/tasks", nil)
 	if err != nil {
 		s.addOutput("Error creating task: " + err.Error())
 		return nil
@@ -351,6 +376,39 @@ func (s *GUIState) createTask(g *gocui.Gui, v *gocui.View) error {
 	return nil
 }
 
+func (s *GUIState) loadTasks() {
+	cursor := ""
+	for {
+		resp, err := apiRequest(http.MethodGet, "http://localhost:8080/tasks?limit=500&cursor="+url.QueryEscape(cursor), nil)
+		if err != nil {
+			s.addOutput("Error loading tasks: " + err.Error())
+			return
+		}
+
+		var page struct {
+			Tasks []struct {
+				ID     string `json:"id"`
+				Status string `json:"status"`
+			} `json:"tasks"`
+			NextCursor string `json:"next_cursor"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			s.addOutput("Error decoding tasks: " + err.Error())
+			return
+		}
+
+		for _, task := range page.Tasks {
+			s.Tasks = append(s.Tasks, TaskInfo{ID: task.ID, Status: task.Status})
+		}
+		if page.NextCursor == "" {
+			return
+		}
+		cursor = page.NextCursor
+	}
+}
+
 func transGDLink(original string) string {
 	if strings.Contains(original, "drive.google.com/file/d/") {
 		re := regexp.MustCompile(`/file/d/([^/]+)`)
@@ -387,11 +445,7 @@ func (s *GUIState) addFile(g *gocui.Gui, v *gocui.View, taskID, url string) erro
 	data := map[string]string{"url": transformedURL}
 	jsonData, _ := json.Marshal(data)
 
-	resp, err := http.Post(
-		"http://localhost:8080/tasks/"+taskID,
-		"application/json",
-		bytes.NewBuffer(jsonData),
-	)
+	resp, err := apiRequest(http.MethodPost, "http://localhost:8080/tasks/"+taskID, bytes.NewBuffer(jsonData))
 	if err != nil {
 		s.addOutput("Error adding file: " + err.Error())
 		return nil
@@ -439,7 +493,7 @@ func (s *GUIState) showStatus(g *gocui.Gui, v *gocui.View) error {
 }
 
 func (s *GUIState) showStatusLittle(taskID string) {
-	resp, err := http.Get("http://localhost:8080/status/" + taskID)
+	resp, err := apiRequest(http.MethodGet, "http://localhost:8080/status/"+taskID, nil)
 	if err != nil {
 		s.addOutput("Error getting status: " + err.Error())
 		return
@@ -494,7 +548,28 @@ func (s *GUIState) downloadArchive(g *gocui.Gui, v *gocui.View) error {
 }
 
 func (s *GUIState) downloadArchiveLittle(taskID string) {
-	resp, err := http.Get("http://localhost:8080/download/" + taskID)
+	statusResp, err := apiRequest(http.MethodGet, "http://localhost:8080/status/"+taskID, nil)
+	if err != nil {
+		s.addOutput("Error getting status: " + err.Error())
+		return
+	}
+	var status TaskStatus
+	err = json.NewDecoder(statusResp.Body).Decode(&status)
+	statusResp.Body.Close()
+	if err != nil {
+		s.addOutput("Error parsing status: " + err.Error())
+		return
+	}
+	if status.Archive == "" {
+		s.addOutput("Archive not ready for task " + taskID)
+		return
+	}
+
+	link := status.Archive
+	if strings.HasPrefix(link, "/") {
+		link = "http://localhost:8080" + link
+	}
+	resp, err := apiRequest(http.MethodGet, link, nil)
 	if err != nil {
 		s.addOutput("Error downloading archive: " + err.Error())
 		return
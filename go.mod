@@ -2,10 +2,13 @@ module test_ex_zip
 
 go 1.24.5
 
-require golang.org/x/sync v0.16.0
+require (
+	github.com/awesome-gocui/gocui v1.1.0
+	golang.org/x/sync v0.16.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
-	github.com/awesome-gocui/gocui v1.1.0 // indirect
 	github.com/gdamore/encoding v1.0.0 // indirect
 	github.com/gdamore/tcell/v2 v2.4.0 // indirect
 	github.com/lucasb-eyer/go-colorful v1.0.3 // indirect
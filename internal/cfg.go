@@ -1,37 +1,283 @@
 package internal
 
 import (
+	"bytes"
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Addr        string   `json:"server_address"`
-	MaxTasks    int      `json:"max_tasks"`
-	MaxFiles    int      `json:"max_files"`
-	PrTimeout   string   `json:"processing_timeout"`
-	DwnTimeout  string   `json:"download_timeout"`
-	TempDir     string   `json:"temp_dir"`
-	ArchiveDir  string   `json:"archive_dir"`
-	AllowedExts []string `json:"allowed_exts"`
+	Addr         string   `json:"server_address"`
+	MaxTasks     int      `json:"max_tasks"`
+	MaxFiles     int      `json:"max_files"`
+	PrTimeout    string   `json:"processing_timeout"`
+	DwnTimeout   string   `json:"download_timeout"`
+	TempDir      string   `json:"temp_dir"`
+	ArchiveDir   string   `json:"archive_dir"`
+	StateDir     string   `json:"state_dir"`
+	AllowedExts  []string `json:"allowed_exts"`
+	Policy       string   `json:"failure_policy"`
+	TaskIDFormat string   `json:"task_id_format"`
+
+	Profiles       map[string]Profile `json:"profiles"`
+	DefaultProfile string             `json:"default_profile"`
+
+	StageDownloads bool            `json:"stage_downloads"`
+	Store          StoreConfig     `json:"archive_store"`
+	Retention      RetentionConfig `json:"retention"`
+	Disk           DiskConfig      `json:"disk"`
+
+	PublicURL      string `json:"public_url"`
+	DownloadSecret string `json:"download_secret"`
+	LinkTTL        string `json:"download_link_ttl"`
+	AllowUnsigned  bool   `json:"allow_unsigned_downloads"`
+
+	IdempotencyTTL string `json:"idempotency_ttl"`
+	MaxStatusWait  string `json:"max_status_wait"`
+	DrainTimeout   string `json:"drain_timeout"`
+
+	Webhook WebhookConfig `json:"webhook"`
+	HTTP    HTTPConfig    `json:"http"`
+	Auth    AuthConfig    `json:"auth"`
+
+	RateLimit RateLimitConfig `json:"rate_limit"`
 }
 
-func (c *Config) MakeTimePr() (time.Duration, error) {
-	if c.PrTimeout == "" {
-		return 5 * time.Minute, nil
+// Each client, told apart by API key or else by IP, gets its own bucket per
+// group of endpoints.
+type RateLimitConfig struct {
+	Create   RateLimit `json:"create"`
+	Add      RateLimit `json:"add"`
+	Download RateLimit `json:"download"`
+}
+
+// RateLimit allows Requests per Period with bursts of up to Burst, which
+// defaults to Requests. Zero Requests turns the limit off.
+type RateLimit struct {
+	Requests int    `json:"requests"`
+	Period   string `json:"period"`
+	Burst    int    `json:"burst"`
+}
+
+func (l *RateLimit) MakeTimePeriod() (time.Duration, error) {
+	return parseOptionalDuration(l.Period, time.Minute)
+}
+
+// The API stays open until Enabled is set. More keys can be created through
+// the admin endpoints; they are kept in state_dir.
+type AuthConfig struct {
+	Enabled bool           `json:"enabled"`
+	Keys    []APIKeyConfig `json:"keys"`
+}
+
+// ID names the caller: it becomes the owner of the tasks created with Key.
+type APIKeyConfig struct {
+	ID     string    `json:"id"`
+	Key    string    `json:"key"`
+	Admin  bool      `json:"admin"`
+	Limits KeyLimits `json:"limits"`
+}
+
+// Zero limits are unlimited and empty Profiles allows every profile.
+type KeyLimits struct {
+	ConcurrentTasks int      `json:"concurrent_tasks,omitempty"`
+	TasksPerDay     int      `json:"tasks_per_day,omitempty"`
+	BytesPerDay     int64    `json:"bytes_per_day,omitempty"`
+	Profiles        []string `json:"profiles,omitempty"`
+}
+
+// MinKeyLength keeps configured secrets out of guessing range.
+const MinKeyLength = 16
+
+// ValidKeyID accepts IDs that are safe to use as a path segment.
+func ValidKeyID(id string) bool {
+	if id == "" || len(id) > 64 || id == "." || id == ".." {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
 	}
-	return time.ParseDuration(c.PrTimeout)
+	return true
 }
 
-func (c *Config) MakeTimeDwn() (time.Duration, error) {
-	if c.DwnTimeout == "" {
-		return 30 * time.Millisecond, nil
+type StoreConfig struct {
+	Type      string `json:"type"`
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	PathStyle bool   `json:"path_style"`
+	Redirect  bool   `json:"redirect"`
+}
+
+// Empty AfterCompletion or AfterDownload disables that expiry rule. The
+// first of Rules whose label the task carries replaces both.
+type RetentionConfig struct {
+	AfterCompletion string          `json:"after_completion"`
+	AfterDownload   string          `json:"after_download"`
+	TempMaxAge      string          `json:"temp_max_age"`
+	SweepInterval   string          `json:"sweep_interval"`
+	Rules           []RetentionRule `json:"rules"`
+}
+
+type RetentionRule struct {
+	Label           string `json:"label"`
+	AfterCompletion string `json:"after_completion"`
+	AfterDownload   string `json:"after_download"`
+}
+
+func (r *RetentionRule) MakeTimeCompletion() (time.Duration, error) {
+	return parseOptionalDuration(r.AfterCompletion, 0)
+}
+
+func (r *RetentionRule) MakeTimeDownload() (time.Duration, error) {
+	return parseOptionalDuration(r.AfterDownload, 0)
+}
+
+const (
+	WhenFullRefuse = "refuse"
+	WhenFullQueue  = "queue"
+)
+
+// QuotaBytes of zero disables the quota; free space is still checked against MinFreeBytes.
+type DiskConfig struct {
+	QuotaBytes   int64  `json:"quota_bytes"`
+	MinFreeBytes int64  `json:"min_free_bytes"`
+	WhenFull     string `json:"when_full"`
+	Preflight    bool   `json:"preflight"`
+}
+
+// URL receives every finished task in addition to per-task callbacks.
+// Payloads are signed only when Secret is set.
+type WebhookConfig struct {
+	URL             string `json:"url"`
+	Secret          string `json:"secret"`
+	MaxAttempts     int    `json:"max_attempts"`
+	Backoff         string `json:"backoff"`
+	MaxBackoff      string `json:"max_backoff"`
+	Timeout         string `json:"timeout"`
+	DeadLetterLimit int    `json:"dead_letter_limit"`
+}
+
+// An empty WriteTimeout leaves responses without a deadline; downloads and
+// event streams clear it anyway. MaxBodyBytes caps every request body.
+type HTTPConfig struct {
+	ReadHeaderTimeout string    `json:"read_header_timeout"`
+	ReadTimeout       string    `json:"read_timeout"`
+	WriteTimeout      string    `json:"write_timeout"`
+	IdleTimeout       string    `json:"idle_timeout"`
+	MaxHeaderBytes    int       `json:"max_header_bytes"`
+	MaxBodyBytes      int64     `json:"max_body_bytes"`
+	TLS               TLSConfig `json:"tls"`
+}
+
+const (
+	ClientAuthRequire  = "require"
+	ClientAuthOptional = "optional"
+)
+
+// TLS is enabled by CertFile and KeyFile. ClientCAFile turns on client
+// certificate checks; ClientAuth defaults to require.
+type TLSConfig struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file"`
+	ClientAuth   string `json:"client_auth"`
+}
+
+func (t *TLSConfig) Enabled() bool {
+	return t.CertFile != ""
+}
+
+func (h *HTTPConfig) MakeTimeReadHeader() (time.Duration, error) {
+	return parseOptionalDuration(h.ReadHeaderTimeout, 10*time.Second)
+}
+
+func (h *HTTPConfig) MakeTimeRead() (time.Duration, error) {
+	return parseOptionalDuration(h.ReadTimeout, time.Minute)
+}
+
+func (h *HTTPConfig) MakeTimeWrite() (time.Duration, error) {
+	return parseOptionalDuration(h.WriteTimeout, 0)
+}
+
+func (h *HTTPConfig) MakeTimeIdle() (time.Duration, error) {
+	return parseOptionalDuration(h.IdleTimeout, 2*time.Minute)
+}
+
+func (w *WebhookConfig) MakeTimeBackoff() (time.Duration, error) {
+	return parseOptionalDuration(w.Backoff, time.Second)
+}
+
+func (w *WebhookConfig) MakeTimeMaxBackoff() (time.Duration, error) {
+	return parseOptionalDuration(w.MaxBackoff, 5*time.Minute)
+}
+
+func (w *WebhookConfig) MakeTimeTimeout() (time.Duration, error) {
+	return parseOptionalDuration(w.Timeout, 10*time.Second)
+}
+
+func (r *RetentionConfig) MakeTimeCompletion() (time.Duration, error) {
+	return parseOptionalDuration(r.AfterCompletion, 0)
+}
+
+func (r *RetentionConfig) MakeTimeDownload() (time.Duration, error) {
+	return parseOptionalDuration(r.AfterDownload, 0)
+}
+
+func (r *RetentionConfig) MakeTimeTemp() (time.Duration, error) {
+	return parseOptionalDuration(r.TempMaxAge, time.Hour)
+}
+
+func (r *RetentionConfig) MakeTimeSweep() (time.Duration, error) {
+	return parseOptionalDuration(r.SweepInterval, 5*time.Minute)
+}
+
+func parseOptionalDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
 	}
-	return time.ParseDuration(c.DwnTimeout)
+	return time.ParseDuration(s)
+}
+
+func (c *Config) MakeTimePr() (time.Duration, error) {
+	return parseOptionalDuration(c.PrTimeout, 5*time.Minute)
+}
+
+func (c *Config) MakeTimeDwn() (time.Duration, error) {
+	return parseOptionalDuration(c.DwnTimeout, 30*time.Second)
 }
 
+func (c *Config) MakeTimeLink() (time.Duration, error) {
+	return parseOptionalDuration(c.LinkTTL, 24*time.Hour)
+}
+
+func (c *Config) MakeTimeIdempotency() (time.Duration, error) {
+	return parseOptionalDuration(c.IdempotencyTTL, 24*time.Hour)
+}
+
+func (c *Config) MakeTimeMaxWait() (time.Duration, error) {
+	return parseOptionalDuration(c.MaxStatusWait, time.Minute)
+}
+
+func (c *Config) MakeTimeDrain() (time.Duration, error) {
+	return parseOptionalDuration(c.DrainTimeout, 30*time.Second)
+}
+
+// LoadConfig reads a JSON or, by extension, YAML config file, applies the
+// ARCHIVE_* environment overrides and validates the result.
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -46,10 +292,50 @@ func LoadConfig(path string) (*Config, error) {
 		DwnTimeout:  "30s",
 		TempDir:     filepath.Join(os.TempDir(), "archive-service", "temp"),
 		ArchiveDir:  filepath.Join(os.TempDir(), "archive-service", "archives"),
+		StateDir:    filepath.Join(os.TempDir(), "archive-service", "state"),
 		AllowedExts: []string{".pdf", ".jpeg"},
+		Policy:      string(PolicyBestEffort),
+
+		StageDownloads: true,
+
+		LinkTTL:       "24h",
+		AllowUnsigned: true,
+
+		IdempotencyTTL: "24h",
+		MaxStatusWait:  "60s",
+		DrainTimeout:   "30s",
+
+		Retention: RetentionConfig{
+			AfterCompletion: "72h",
+			TempMaxAge:      "1h",
+			SweepInterval:   "5m",
+		},
+		Disk: DiskConfig{
+			MinFreeBytes: 512 << 20,
+			WhenFull:     WhenFullRefuse,
+			Preflight:    true,
+		},
+		Webhook: WebhookConfig{
+			MaxAttempts:     6,
+			Backoff:         "1s",
+			MaxBackoff:      "5m",
+			Timeout:         "10s",
+			DeadLetterLimit: 1000,
+		},
+		HTTP: HTTPConfig{
+			ReadHeaderTimeout: "10s",
+			ReadTimeout:       "1m",
+			IdleTimeout:       "2m",
+			MaxHeaderBytes:    64 << 10,
+			MaxBodyBytes:      1 << 20,
+		},
 	}
 
-	if err = json.Unmarshal(data, &cfg); err != nil {
+	if err = decodeConfig(path, data, cfg); err != nil {
+		return nil, err
+	}
+	problems := applyEnv(reflect.ValueOf(cfg).Elem(), EnvPrefix, os.LookupEnv)
+	if err = cfg.validate(problems); err != nil {
 		return nil, err
 	}
 
@@ -59,6 +345,68 @@ func LoadConfig(path string) (*Config, error) {
 	if err = os.MkdirAll(cfg.ArchiveDir, 0755); err != nil {
 		return nil, err
 	}
+	if err = os.MkdirAll(cfg.StateDir, 0755); err != nil {
+		return nil, err
+	}
 
 	return cfg, nil
 }
+
+// decodeConfig rejects unknown keys. YAML goes through the JSON tags, so
+// both formats share the same key names.
+func decodeConfig(path string, data []byte, cfg *Config) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var doc any
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		if doc == nil {
+			return nil
+		}
+		var err error
+		if data, err = json.Marshal(doc); err != nil {
+			return err
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(cfg)
+}
+
+// restartOnly lists the settings that size resources or are baked into them
+// at startup, so a reload cannot change them.
+var restartOnly = []struct {
+	name  string
+	field func(*Config) any
+}{
+	{"server_address", func(c *Config) any { return &c.Addr }},
+	{"max_tasks", func(c *Config) any { return &c.MaxTasks }},
+	{"temp_dir", func(c *Config) any { return &c.TempDir }},
+	{"archive_dir", func(c *Config) any { return &c.ArchiveDir }},
+	{"state_dir", func(c *Config) any { return &c.StateDir }},
+	{"task_id_format", func(c *Config) any { return &c.TaskIDFormat }},
+	{"archive_store", func(c *Config) any { return &c.Store }},
+	{"public_url", func(c *Config) any { return &c.PublicURL }},
+	{"download_secret", func(c *Config) any { return &c.DownloadSecret }},
+	{"idempotency_ttl", func(c *Config) any { return &c.IdempotencyTTL }},
+	{"http", func(c *Config) any { return &c.HTTP }},
+	{"rate_limit", func(c *Config) any { return &c.RateLimit }},
+}
+
+// Reload returns next with the restart-only settings of c kept, and the names
+// of those that next tried to change.
+func (c *Config) Reload(next *Config) (*Config, []string) {
+	merged := *next
+	var ignored []string
+	for _, f := range restartOnly {
+		old := reflect.ValueOf(f.field(c)).Elem()
+		cur := reflect.ValueOf(f.field(&merged)).Elem()
+		if !reflect.DeepEqual(old.Interface(), cur.Interface()) {
+			ignored = append(ignored, f.name)
+			cur.Set(old)
+		}
+	}
+	return &merged, ignored
+}
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the environment variables that override config values.
+// The rest of the name is the upper-cased JSON key path joined by
+// underscores, e.g. ARCHIVE_MAX_FILES or ARCHIVE_RETENTION_AFTER_COMPLETION.
+// Lists are comma-separated. Profiles and retention rules have no variables.
+const EnvPrefix = "ARCHIVE_"
+
+// applyEnv sets the fields of v that have a variable in the environment and
+// returns the variables whose values could not be parsed.
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) []string {
+	var problems []string
+	t := v.Type()
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + strings.ToUpper(name)
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			problems = append(problems, applyEnv(field, key+"_", lookup)...)
+			continue
+		}
+		value, ok := lookup(key)
+		if !ok {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int, reflect.Int64:
+			n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not an integer", key, value))
+				continue
+			}
+			field.SetInt(n)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(strings.TrimSpace(value))
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a boolean", key, value))
+				continue
+			}
+			field.SetBool(b)
+		case reflect.Slice:
+			if field.Type().Elem().Kind() != reflect.String {
+				continue
+			}
+			var items []string
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"test_ex_zip/internal"
+	"test_ex_zip/internal/service"
+	"time"
+)
+
+type callerKey struct{}
+
+// Authenticate requires a bearer API key once auth is enabled. Downloads
+// through a signed link are authorized by the signature and skip the key.
+func (h *TaskHandler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.manager.AuthEnabled() || isSignedDownload(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		token = strings.TrimSpace(token)
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="archive"`)
+			respondError(w, http.StatusUnauthorized, "missing api key")
+			return
+		}
+		key, err := h.manager.Authenticate(token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="archive", error="invalid_token"`)
+			respondError(w, http.StatusUnauthorized, "invalid api key")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, key)))
+	})
+}
+
+func isSignedDownload(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	download := strings.HasPrefix(r.URL.Path, "/download/") ||
+		strings.HasPrefix(r.URL.Path, "/groups/") && strings.HasSuffix(r.URL.Path, "/download")
+	return download && service.IsSignedLink(r.URL.Query())
+}
+
+// caller returns the key the request was made with. There is none while
+// auth is disabled or for signed downloads.
+func caller(r *http.Request) (service.APIKey, bool) {
+	key, ok := r.Context().Value(callerKey{}).(service.APIKey)
+	return key, ok
+}
+
+// callerID is the ID of the request's key, recorded as the creator of tasks
+// and schedules.
+func callerID(r *http.Request) string {
+	key, _ := caller(r)
+	return key.ID
+}
+
+// tenant is what the caller may see: the tasks of its own key, or all of
+// them for admin keys, signed downloads and while auth is disabled.
+func tenant(r *http.Request) service.Tenant {
+	if key, ok := caller(r); ok && !key.Admin {
+		return service.Tenant(key.ID)
+	}
+	return service.AllTenants
+}
+
+// requireAdmin answers 403 unless the caller holds an admin key. Everything
+// is allowed while auth is disabled.
+func (h *TaskHandler) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !h.manager.AuthEnabled() {
+		return true
+	}
+	if key, ok := caller(r); ok && key.Admin {
+		return true
+	}
+	respondError(w, http.StatusForbidden, "admin api key required")
+	return false
+}
+
+// owner resolves the owner of a new task or schedule: the caller's key ID
+// by default. Only admins may act for another owner.
+func owner(w http.ResponseWriter, r *http.Request, requested string) (string, bool) {
+	key, ok := caller(r)
+	switch {
+	case !ok:
+		return requested, true
+	case requested == "" || requested == key.ID:
+		return key.ID, true
+	case key.Admin:
+		return requested, true
+	}
+	respondError(w, http.StatusForbidden, "owner must match the api key")
+	return "", false
+}
+
+// respondQuota answers 429 with the exhausted limit and, for daily limits,
+// when it resets.
+func respondQuota(w http.ResponseWriter, err *service.QuotaError) {
+	retryAfter(w, err.ResetAt)
+	respondJSON(w, http.StatusTooManyRequests, struct {
+		Error   string    `json:"error"`
+		Quota   string    `json:"quota"`
+		Limit   int64     `json:"limit"`
+		ResetAt time.Time `json:"reset_at,omitzero"`
+	}{"quota exceeded", err.Quota, err.Limit, err.ResetAt})
+}
+
+func retryAfter(w http.ResponseWriter, at time.Time) {
+	if !at.IsZero() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(at).Seconds())+1))
+	}
+}
+
+func (h *TaskHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	var request struct {
+		ID     string             `json:"id"`
+		Admin  bool               `json:"admin"`
+		Limits internal.KeyLimits `json:"limits"`
+	}
+	if !decodeJSON(w, r, &request, false) {
+		return
+	}
+
+	key, secret, err := h.manager.CreateKey(request.ID, request.Admin, request.Limits)
+	switch {
+	case err == nil:
+		respondJSON(w, http.StatusCreated, struct {
+			service.APIKey
+			Key string `json:"key"`
+		}{key, secret})
+	case errors.Is(err, service.ErrInvalidKey):
+		respondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrKeyExists):
+		respondError(w, http.StatusConflict, "api key already exists")
+	default:
+		respondError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+func (h *TaskHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string][]service.KeyInfo{"keys": h.manager.ListKeys()})
+}
+
+func (h *TaskHandler) DeleteKey(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	switch err := h.manager.DeleteKey(r.PathValue("id")); {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, service.ErrKeyNotFound):
+		respondError(w, http.StatusNotFound, "api key not found")
+	case errors.Is(err, service.ErrKeyInConfig):
+		respondError(w, http.StatusConflict, "api key is defined in the config")
+	default:
+		respondError(w, http.StatusInternalServerError, "internal error")
+	}
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"test_ex_zip/internal/service"
+	"time"
+)
+
+const sseHeartbeat = 15 * time.Second
+
+// TaskEvents streams the events of one task as SSE. A fresh stream opens with
+// the current status, a resumed one replays the missed events; either ends
+// once the task finishes.
+func (h *TaskHandler) TaskEvents(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := h.taskID(w, r)
+	if !ok {
+		return
+	}
+	task, err := h.manager.GetTask(taskID)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "task not found")
+		return
+	}
+	lastID, resume, ok := lastEventID(w, r)
+	if !ok {
+		return
+	}
+
+	sub, backlog, latest, complete := h.manager.Events().Subscribe(taskID, lastID)
+	defer sub.Cancel()
+
+	rc := startSSE(w)
+	for _, e := range backlog {
+		if writeEvent(w, e) != nil || e.Terminal() {
+			return
+		}
+	}
+	if snapshot := h.manager.Snapshot(task, latest); !resume || !complete || snapshot.Terminal() {
+		if writeEvent(w, snapshot) != nil || snapshot.Terminal() {
+			return
+		}
+	}
+	rc.Flush()
+
+	h.pumpEvents(w, r, rc, sub, true, nil)
+}
+
+// Events streams the events of all tasks as SSE.
+func (h *TaskHandler) Events(w http.ResponseWriter, r *http.Request) {
+	lastID, _, ok := lastEventID(w, r)
+	if !ok {
+		return
+	}
+
+	sub, backlog, _, _ := h.manager.Events().Subscribe("", lastID)
+	defer sub.Cancel()
+
+	var visible func(service.Event) bool
+	if t := tenant(r); t != service.AllTenants {
+		visible = func(e service.Event) bool {
+			_, err := h.manager.GetTaskFor(t, e.TaskID)
+			return err == nil
+		}
+	}
+
+	rc := startSSE(w)
+	for _, e := range backlog {
+		if visible != nil && !visible(e) {
+			continue
+		}
+		if writeEvent(w, e) != nil {
+			return
+		}
+	}
+	rc.Flush()
+
+	h.pumpEvents(w, r, rc, sub, false, visible)
+}
+
+// pumpEvents forwards live events until the client leaves or the
+// subscription is dropped for falling behind; the client then resumes with
+// Last-Event-ID. A visible filter drops the events of other tenants.
+func (h *TaskHandler) pumpEvents(w http.ResponseWriter, r *http.Request, rc *http.ResponseController, sub *service.Subscription, untilTerminal bool, visible func(service.Event) bool) {
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if visible != nil && !visible(e) {
+				continue
+			}
+			if writeEvent(w, e) != nil || rc.Flush() != nil {
+				return
+			}
+			if untilTerminal && e.Terminal() {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// lastEventID reads the resume point of a reconnecting client. A fresh
+// client gets math.MaxUint64, so no history is replayed.
+func lastEventID(w http.ResponseWriter, r *http.Request) (uint64, bool, bool) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	if v == "" {
+		return math.MaxUint64, false, true
+	}
+	id, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid Last-Event-ID")
+		return 0, false, false
+	}
+	return id, true, true
+}
+
+func startSSE(w http.ResponseWriter) *http.ResponseController {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	clearWriteDeadline(w)
+	w.WriteHeader(http.StatusOK)
+	return http.NewResponseController(w)
+}
+
+func writeEvent(w http.ResponseWriter, e service.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
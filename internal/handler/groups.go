@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"test_ex_zip/internal"
+	"test_ex_zip/internal/service"
+	"time"
+)
+
+type groupResponse struct {
+	ID          string                      `json:"id"`
+	Name        string                      `json:"name,omitempty"`
+	Tasks       []string                    `json:"tasks"`
+	CreatedAt   time.Time                   `json:"created_at"`
+	Status      internal.TaskStatus         `json:"status"`
+	Counts      map[internal.TaskStatus]int `json:"counts"`
+	Members     []service.GroupMember       `json:"members"`
+	MergeStatus string                      `json:"merge_status,omitempty"`
+	MergeError  string                      `json:"merge_error,omitempty"`
+	Archive     string                      `json:"archive,omitempty"`
+	ArchiveHash string                      `json:"archive_sha256,omitempty"`
+	ArchiveSize int64                       `json:"archive_size,omitempty"`
+}
+
+func (h *TaskHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name  string   `json:"name"`
+		Tasks []string `json:"tasks"`
+		Merge bool     `json:"merge"`
+	}
+	if !decodeJSON(w, r, &request, false) {
+		return
+	}
+	for _, id := range request.Tasks {
+		if !h.manager.ValidID(id) {
+			respondError(w, http.StatusBadRequest, "invalid task id")
+			return
+		}
+	}
+
+	group, err := h.manager.CreateGroup(tenant(r), request.Name, request.Tasks, request.Merge)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidGroup):
+			respondError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, service.ErrTaskNotFound):
+			respondError(w, http.StatusNotFound, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+	h.respondGroup(w, http.StatusCreated, group.ID)
+}
+
+func (h *TaskHandler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.groupID(w, r)
+	if !ok {
+		return
+	}
+	h.respondGroup(w, http.StatusOK, id)
+}
+
+// MergeGroup requests the merged archive. Poll the group until merge_status
+// is completed to get the archive link.
+func (h *TaskHandler) MergeGroup(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.groupID(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.manager.MergeGroup(id); err != nil {
+		respondError(w, http.StatusNotFound, "group not found")
+		return
+	}
+	h.respondGroup(w, http.StatusAccepted, id)
+}
+
+func (h *TaskHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.groupID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.manager.DeleteGroup(r.Context(), id); err != nil {
+		if errors.Is(err, service.ErrGroupNotFound) {
+			respondError(w, http.StatusNotFound, "group not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "failed to delete archive")
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TaskHandler) DownloadGroup(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.groupID(w, r)
+	if !ok {
+		return
+	}
+	group, _, err := h.manager.GetGroup(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "archive not available")
+		return
+	}
+
+	if group.MergeStatus != service.MergeCompleted {
+		respondError(w, http.StatusNotFound, "archive not available")
+		return
+	}
+	q, ip := r.URL.Query(), clientIP(r)
+	if err := h.manager.VerifyGroupLink(id, q, ip, false); err != nil {
+		respondLinkError(w, err)
+		return
+	}
+
+	h.serveArchive(w, r, group.ArchiveKey, group.ArchiveHash, "group-"+id+".zip", group.MergedAt, func() error {
+		return h.manager.VerifyGroupLink(id, q, ip, true)
+	})
+}
+
+func (h *TaskHandler) respondGroup(w http.ResponseWriter, code int, id string) {
+	group, status, err := h.manager.GetGroup(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "group not found")
+		return
+	}
+
+	response := groupResponse{
+		ID:          group.ID,
+		Name:        group.Name,
+		Tasks:       group.TaskIDs,
+		CreatedAt:   group.CreatedAt,
+		Status:      status.Status,
+		Counts:      status.Counts,
+		Members:     status.Members,
+		MergeStatus: group.MergeStatus,
+		MergeError:  group.MergeError,
+	}
+	if group.MergeStatus == service.MergeCompleted {
+		response.ArchiveHash = group.ArchiveHash
+		response.ArchiveSize = group.ArchiveSize
+		if link, err := h.manager.GroupDownloadLink(id, service.LinkOptions{}); err == nil {
+			response.Archive = link.URL
+		}
+	}
+	respondJSON(w, code, response)
+}
+
+func (h *TaskHandler) groupID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id, ok := h.pathID(w, r, "invalid group id")
+	if t := tenant(r); ok && t != service.AllTenants {
+		if _, _, err := h.manager.GetGroupFor(t, id); err != nil {
+			respondError(w, http.StatusNotFound, "group not found")
+			return "", false
+		}
+	}
+	return id, ok
+}
@@ -3,13 +3,17 @@ package handler
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"log"
+	"maps"
+	"net"
 	"net/http"
-	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"test_ex_zip/internal"
 	"test_ex_zip/internal/service"
+	"test_ex_zip/internal/storage"
 	"time"
 )
 
@@ -22,11 +26,73 @@ func NewTaskHandler(manager *service.TaskManager) *TaskHandler {
 }
 
 func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
-	task, err := h.manager.CreateTask()
+	var request struct {
+		Policy    string                    `json:"policy"`
+		Stream    bool                      `json:"stream"`
+		LegalHold bool                      `json:"legal_hold"`
+		Labels    []string                  `json:"labels"`
+		Metadata  map[string]string         `json:"metadata"`
+		Owner     string                    `json:"owner"`
+		Files     []fileRequest             `json:"files"`
+		Callback  string                    `json:"callback_url"`
+		Profile   string                    `json:"profile"`
+		Overrides internal.ProfileOverrides `json:"overrides"`
+	}
+	if !decodeJSON(w, r, &request, true) {
+		return
+	}
+	files, ok := fileSpecs(request.Files)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if request.Owner, ok = owner(w, r, request.Owner); !ok {
+		return
+	}
+	if request.LegalHold && !h.requireAdmin(w, r) {
+		return
+	}
+
+	task, err := h.manager.CreateTask(service.TaskOptions{
+		Policy:    request.Policy,
+		Stream:    request.Stream,
+		LegalHold: request.LegalHold,
+		Labels:    request.Labels,
+		Metadata:  request.Metadata,
+		Owner:     request.Owner,
+		CreatedBy: callerID(r),
+		Files:     files,
+		Callback:  request.Callback,
+		Profile:   request.Profile,
+		Overrides: request.Overrides,
+	})
 	if err != nil {
-		if errors.Is(err, service.ErrServerBusy) {
+		var batch *service.BatchError
+		var quota *service.QuotaError
+		switch {
+		case errors.As(err, &batch):
+			respondFileErrors(w, batch)
+		case errors.As(err, &quota):
+			respondQuota(w, quota)
+		case errors.Is(err, service.ErrProfileDenied):
+			respondError(w, http.StatusForbidden, err.Error())
+		case errors.Is(err, service.ErrServerBusy):
 			respondError(w, http.StatusTooManyRequests, "server busy")
-		} else {
+		case errors.Is(err, service.ErrShuttingDown):
+			respondError(w, http.StatusServiceUnavailable, "server shutting down")
+		case errors.Is(err, internal.ErrInvalidPolicy):
+			respondError(w, http.StatusBadRequest, "invalid failure policy")
+		case errors.Is(err, service.ErrInvalidLabel):
+			respondError(w, http.StatusBadRequest, "invalid label")
+		case errors.Is(err, service.ErrInvalidMetadata):
+			respondError(w, http.StatusBadRequest, "invalid metadata")
+		case errors.Is(err, service.ErrInvalidCallback):
+			respondError(w, http.StatusBadRequest, "invalid callback url")
+		case errors.Is(err, internal.ErrUnknownProfile), errors.Is(err, internal.ErrInvalidOverride):
+			respondError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, service.ErrInsufficientStorage):
+			respondError(w, http.StatusInsufficientStorage, "insufficient storage")
+		default:
 			respondError(w, http.StatusInternalServerError, "internal error")
 		}
 		return
@@ -35,24 +101,70 @@ func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *TaskHandler) AddFile(w http.ResponseWriter, r *http.Request) {
-	taskID := r.PathValue("id")
+	taskID, ok := h.taskID(w, r)
+	if !ok {
+		return
+	}
 
-	var request struct {
-		URL string `json:"url"`
+	var request fileRequest
+	if !decodeJSON(w, r, &request, false) {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+	if request.Size < 0 {
 		respondError(w, http.StatusBadRequest, "invalid request")
 		return
 	}
 
-	if err := h.manager.AddFile(taskID, request.URL); err != nil {
+	if err := h.manager.AddFile(taskID, request.spec()); err != nil {
 		switch {
 		case errors.Is(err, service.ErrTaskNotFound):
 			respondError(w, http.StatusNotFound, "task not found")
 		case errors.Is(err, service.ErrMaxFiles):
 			respondError(w, http.StatusBadRequest, "max files reached")
+		case errors.Is(err, service.ErrTaskStarted):
+			respondError(w, http.StatusConflict, "task already started")
 		case errors.Is(err, service.ErrInvalidFileType):
 			respondError(w, http.StatusBadRequest, "invalid file type")
+		case errors.Is(err, service.ErrInvalidFileName):
+			respondError(w, http.StatusBadRequest, "invalid file name")
+		case errors.Is(err, service.ErrDuplicateFileName):
+			respondError(w, http.StatusBadRequest, "duplicate file name")
+		default:
+			respondError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TaskHandler) AddFiles(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := h.taskID(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Files []fileRequest `json:"files"`
+		Start bool          `json:"start"`
+	}
+	if !decodeJSON(w, r, &request, false) {
+		return
+	}
+	files, ok := fileSpecs(request.Files)
+	if !ok || len(files) == 0 {
+		respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	if err := h.manager.AddFiles(taskID, files, request.Start); err != nil {
+		var batch *service.BatchError
+		switch {
+		case errors.As(err, &batch):
+			respondFileErrors(w, batch)
+		case errors.Is(err, service.ErrTaskNotFound):
+			respondError(w, http.StatusNotFound, "task not found")
+		case errors.Is(err, service.ErrTaskStarted):
+			respondError(w, http.StatusConflict, "task already started")
 		default:
 			respondError(w, http.StatusInternalServerError, "internal error")
 		}
@@ -61,72 +173,625 @@ func (h *TaskHandler) AddFile(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+type fileRequest struct {
+	URL  string `json:"url"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+func (f fileRequest) spec() service.FileSpec {
+	return service.FileSpec{URL: f.URL, Name: f.Name, Size: f.Size}
+}
+
+func fileSpecs(files []fileRequest) ([]service.FileSpec, bool) {
+	specs := make([]service.FileSpec, len(files))
+	for i, f := range files {
+		if f.Size < 0 {
+			return nil, false
+		}
+		specs[i] = f.spec()
+	}
+	return specs, true
+}
+
+func respondFileErrors(w http.ResponseWriter, batch *service.BatchError) {
+	type fileError struct {
+		Index int    `json:"index"`
+		Error string `json:"error"`
+	}
+	errs := make([]fileError, len(batch.Errors))
+	for i, fe := range batch.Errors {
+		errs[i] = fileError{Index: fe.Index, Error: fe.Err.Error()}
+	}
+	respondJSON(w, http.StatusBadRequest, struct {
+		Error string      `json:"error"`
+		Files []fileError `json:"files"`
+	}{
+		Error: "invalid files",
+		Files: errs,
+	})
+}
+
+func (h *TaskHandler) ListTasks(w http.ResponseWriter, r *http.Request) {
+	h.listTasks(w, r, r.URL.Query().Get("q"))
+}
+
+// SearchTasks is ListTasks with a required full-text query over labels,
+// metadata, URLs and file names.
+func (h *TaskHandler) SearchTasks(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		respondError(w, http.StatusBadRequest, "missing query")
+		return
+	}
+	h.listTasks(w, r, query)
+}
+
+func (h *TaskHandler) listTasks(w http.ResponseWriter, r *http.Request, query string) {
+	q := r.URL.Query()
+	filter := service.ListFilter{
+		Tenant: tenant(r),
+		Owner:  q.Get("owner"),
+		Query:  query,
+		Sort:   q.Get("sort"),
+		Desc:   q.Get("order") == "desc",
+		Cursor: q.Get("cursor"),
+	}
+
+	if v := q.Get("label"); v != "" {
+		filter.Labels = strings.Split(v, ",")
+	}
+	if v := q.Get("status"); v != "" {
+		for _, status := range strings.Split(v, ",") {
+			filter.Statuses = append(filter.Statuses, internal.TaskStatus(status))
+		}
+	}
+	if filter.Sort != "" && filter.Sort != service.SortCreated && filter.Sort != service.SortCompleted {
+		respondError(w, http.StatusBadRequest, "invalid sort")
+		return
+	}
+	if order := q.Get("order"); order != "" && order != "asc" && order != "desc" {
+		respondError(w, http.StatusBadRequest, "invalid order")
+		return
+	}
+
+	var err error
+	if filter.CreatedAfter, err = parseTimeParam(q.Get("created_after")); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid created_after")
+		return
+	}
+	if filter.CreatedBefore, err = parseTimeParam(q.Get("created_before")); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid created_before")
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	tasks, next, err := h.manager.ListTasks(filter)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidCursor) {
+			respondError(w, http.StatusBadRequest, "invalid cursor")
+		} else {
+			respondError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusOK, struct {
+		Tasks      []service.TaskSummary `json:"tasks"`
+		NextCursor string                `json:"next_cursor,omitempty"`
+	}{
+		Tasks:      tasks,
+		NextCursor: next,
+	})
+}
+
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
 func (h *TaskHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
-	taskID := r.PathValue("id")
+	taskID, ok := h.taskID(w, r)
+	if !ok {
+		return
+	}
 	task, err := h.manager.GetTask(taskID)
 	if err != nil {
 		respondError(w, http.StatusNotFound, "task not found")
 		return
 	}
 
+	if v := r.URL.Query().Get("wait"); v != "" {
+		wait, err := time.ParseDuration(v)
+		if err != nil || wait < 0 {
+			respondError(w, http.StatusBadRequest, "invalid wait")
+			return
+		}
+		clearWriteDeadline(w)
+		var until []internal.TaskStatus
+		if v := r.URL.Query().Get("until"); v != "" {
+			for _, status := range strings.Split(v, ",") {
+				until = append(until, internal.TaskStatus(status))
+			}
+		}
+		if !h.manager.WaitTask(r.Context(), task, until, wait) {
+			w.Header().Set("X-Wait-Timeout", "true")
+		}
+	}
+
 	task.Mu.Lock()
 	response := struct {
-		Status    internal.TaskStatus `json:"status"`
-		Files     []internal.File     `json:"files"`
-		Archive   string              `json:"archive,omitempty"`
-		CreatedAt time.Time           `json:"created_at"`
+		Status    internal.TaskStatus    `json:"status"`
+		Error     string                 `json:"error,omitempty"`
+		Policy    internal.FailurePolicy `json:"policy"`
+		Settings  internal.TaskSettings  `json:"settings"`
+		Stream    bool                   `json:"stream,omitempty"`
+		Files     []internal.File        `json:"files"`
+		Totals    internal.TaskTotals    `json:"totals"`
+		Archive   string                 `json:"archive,omitempty"`
+		CreatedAt time.Time              `json:"created_at"`
+		LegalHold bool                   `json:"legal_hold,omitempty"`
+		Labels    []string               `json:"labels,omitempty"`
+		Metadata  map[string]string      `json:"metadata,omitempty"`
+		Owner     string                 `json:"owner,omitempty"`
+		CreatedBy string                 `json:"created_by,omitempty"`
+		ExpiresAt *time.Time             `json:"expires_at,omitempty"`
 	}{
 		Status:    task.Status,
+		Error:     task.Error,
+		Policy:    task.Policy,
+		Settings:  task.Settings,
+		Stream:    task.Stream,
 		Files:     make([]internal.File, len(task.Files)),
+		Totals:    task.Totals(time.Now()),
 		CreatedAt: task.CreatedAt,
+		LegalHold: task.LegalHold,
+		Labels:    slices.Clone(task.Labels),
+		Metadata:  maps.Clone(task.Metadata),
+		Owner:     task.Owner,
+		CreatedBy: task.CreatedBy,
 	}
 	copy(response.Files, task.Files)
 
-	if task.Status == internal.StatusCompleted {
-		response.Archive = "/download/" + task.ID
-	}
+	hasArchive := task.HasArchive()
+	streamReady := task.Stream && task.Status == internal.StatusPending && len(task.Files) > 0
 	task.Mu.Unlock()
 
+	if expires := h.manager.ExpiresAt(task); !expires.IsZero() {
+		response.ExpiresAt = &expires
+	}
+
+	if hasArchive || streamReady {
+		link, err := h.manager.DownloadLink(taskID, service.LinkOptions{})
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		response.Archive = link.URL
+		if streamReady {
+			response.Archive += "&stream=1"
+		}
+	}
+
 	respondJSON(w, http.StatusOK, response)
 }
 
 func (h *TaskHandler) DownloadArchive(w http.ResponseWriter, r *http.Request) {
-	taskID := r.PathValue("id")
+	taskID, ok := h.taskID(w, r)
+	if !ok {
+		return
+	}
 	task, err := h.manager.GetTask(taskID)
-	if err != nil || task.Status != internal.StatusCompleted {
+	if err != nil {
 		respondError(w, http.StatusNotFound, "archive not available")
 		return
 	}
 
-	file, err := os.Open(task.ArchivePath)
+	q, ip := r.URL.Query(), clientIP(r)
+	stream := q.Get("stream") == "1"
+
+	task.Mu.Lock()
+	available := task.HasArchive()
+	archiveKey, archiveHash, modTime := task.ArchiveKey, task.ArchiveHash, task.CompletedAt
+	task.Mu.Unlock()
+
+	if !stream && !available {
+		respondError(w, http.StatusNotFound, "archive not available")
+		return
+	}
+	if err := h.manager.VerifyDownloadLink(taskID, q, ip, false); err != nil {
+		respondLinkError(w, err)
+		return
+	}
+
+	// A download is used up only when a body is served, ranged or not.
+	consume := func() error {
+		return h.manager.VerifyDownloadLink(taskID, q, ip, true)
+	}
+	if stream {
+		h.streamArchive(w, r, consume)
+		return
+	}
+
+	h.serveArchive(w, r, archiveKey, archiveHash, taskID+".zip", modTime, func() error {
+		if err := consume(); err != nil {
+			return err
+		}
+		h.manager.MarkDownloaded(task)
+		return nil
+	})
+}
+
+func respondLinkError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrLinkExpired), errors.Is(err, service.ErrLinkExhausted):
+		respondError(w, http.StatusGone, err.Error())
+	default:
+		respondError(w, http.StatusForbidden, err.Error())
+	}
+}
+
+// serveArchive redirects to the store or serves the archive itself.
+// serve is called for GET requests right before a redirect or a full or
+// partial body goes out; its error refuses the request instead.
+func (h *TaskHandler) serveArchive(w http.ResponseWriter, r *http.Request, key, hash, filename string, modTime time.Time, serve func() error) {
+	if u := h.manager.ArchiveRedirect(key, filename); u != "" {
+		if r.Method == http.MethodGet {
+			if err := serve(); err != nil {
+				respondLinkError(w, err)
+				return
+			}
+		}
+		http.Redirect(w, r, u, http.StatusFound)
+		return
+	}
+
+	archive, err := h.manager.OpenArchive(r.Context(), key)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "failed to open archive")
+		if errors.Is(err, storage.ErrNotExist) {
+			respondError(w, http.StatusNotFound, "archive not available")
+		} else {
+			respondError(w, http.StatusInternalServerError, "failed to open archive")
+		}
+		return
+	}
+	defer archive.Close()
+	clearWriteDeadline(w)
+
+	if r.Method == http.MethodGet {
+		w = &serveWriter{ResponseWriter: w, serve: serve}
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Accept-Ranges", "bytes")
+	if hash != "" {
+		w.Header().Set("ETag", `"`+hash+`"`)
+	}
+
+	// ServeContent handles Range, If-Range, If-None-Match, If-Modified-Since and HEAD.
+	http.ServeContent(w, r, filename, modTime, archive)
+}
+
+// serveWriter calls serve when ServeContent starts a 200 or 206 response, so
+// HEAD, 304, 412 and 416 answers do not count as downloads.
+type serveWriter struct {
+	http.ResponseWriter
+	serve   func() error
+	started bool
+	err     error
+}
+
+func (w *serveWriter) WriteHeader(code int) {
+	if w.started {
+		return
+	}
+	w.started = true
+	if code == http.StatusOK || code == http.StatusPartialContent {
+		if w.err = w.serve(); w.err != nil {
+			for _, name := range []string{"Content-Length", "Content-Range", "Content-Disposition", "Accept-Ranges", "ETag", "Last-Modified"} {
+				w.Header().Del(name)
+			}
+			respondLinkError(w.ResponseWriter, w.err)
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *serveWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *serveWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (h *TaskHandler) streamArchive(w http.ResponseWriter, r *http.Request, claim func() error) {
+	taskID, ok := h.taskID(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodGet {
+		respondError(w, http.StatusMethodNotAllowed, "streaming requires GET")
 		return
 	}
-	defer file.Close()
 
-	fileInfo, err := file.Stat()
+	task, err := h.manager.StartStream(taskID, claim)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "failed to get file info")
+		switch {
+		case errors.Is(err, service.ErrLinkExhausted):
+			respondLinkError(w, err)
+		case errors.Is(err, service.ErrTaskNotFound):
+			respondError(w, http.StatusNotFound, "task not found")
+		case errors.Is(err, service.ErrNotStreamTask):
+			respondError(w, http.StatusBadRequest, "task is not in streaming mode")
+		case errors.Is(err, service.ErrStreamNotReady):
+			respondError(w, http.StatusConflict, "stream not ready")
+		case errors.Is(err, service.ErrShuttingDown):
+			respondError(w, http.StatusServiceUnavailable, "server shutting down")
+		default:
+			respondError(w, http.StatusInternalServerError, "internal error")
+		}
 		return
 	}
 
 	w.Header().Set("Content-Disposition", "attachment; filename="+taskID+".zip")
 	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
+	clearWriteDeadline(w)
+	w.WriteHeader(http.StatusOK)
+
+	if err := h.manager.StreamTask(r.Context(), task, w); err != nil {
+		log.Printf("Failed to stream archive %s: %v", taskID, err)
+		// Break the connection so the client does not mistake a truncated zip for a complete one.
+		panic(http.ErrAbortHandler)
+	}
+}
 
-	_, err = io.Copy(w, file)
+func (h *TaskHandler) CreateLink(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := h.taskID(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		TTL          string `json:"ttl"`
+		MaxDownloads int    `json:"max_downloads"`
+		ClientIP     string `json:"client_ip"`
+	}
+	if !decodeJSON(w, r, &request, true) {
+		return
+	}
+
+	opts := service.LinkOptions{
+		MaxDownloads: request.MaxDownloads,
+		ClientIP:     request.ClientIP,
+	}
+	if request.TTL != "" {
+		ttl, err := time.ParseDuration(request.TTL)
+		if err != nil || ttl <= 0 {
+			respondError(w, http.StatusBadRequest, "invalid ttl")
+			return
+		}
+		opts.TTL = ttl
+	}
+	if opts.MaxDownloads < 0 || (opts.ClientIP != "" && net.ParseIP(opts.ClientIP) == nil) {
+		respondError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	link, err := h.manager.DownloadLink(taskID, opts)
 	if err != nil {
-		log.Printf("Failed to send archive: %v", err)
+		if errors.Is(err, service.ErrTaskNotFound) {
+			respondError(w, http.StatusNotFound, "task not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+	respondJSON(w, http.StatusCreated, link)
+}
+
+func (h *TaskHandler) DeleteTask(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := h.taskID(w, r)
+	if !ok {
+		return
+	}
+	switch err := h.manager.DeleteTask(taskID); {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, service.ErrTaskNotFound):
+		respondError(w, http.StatusNotFound, "task not found")
+	case errors.Is(err, service.ErrTaskStarted):
+		respondError(w, http.StatusConflict, "task already started")
+	case errors.Is(err, service.ErrShuttingDown):
+		respondError(w, http.StatusServiceUnavailable, "server shutting down")
+	default:
+		respondError(w, http.StatusInternalServerError, "internal error")
 	}
 }
 
+func (h *TaskHandler) SetLegalHold(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	taskID, ok := h.taskID(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Hold bool `json:"hold"`
+	}
+	if !decodeJSON(w, r, &request, false) {
+		return
+	}
+
+	if err := h.manager.SetLegalHold(taskID, request.Hold); err != nil {
+		if errors.Is(err, service.ErrTaskNotFound) {
+			respondError(w, http.StatusNotFound, "task not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// decodeJSON reads the request body into v, rejecting unknown fields, and
+// answers the request itself when that fails. optional accepts an empty body.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any, optional bool) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil, optional && errors.Is(err, io.EOF):
+		return true
+	case errors.As(err, &tooLarge):
+		respondError(w, http.StatusRequestEntityTooLarge, "request body too large")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		respondError(w, http.StatusBadRequest, "invalid request: "+strings.TrimPrefix(err.Error(), "json: "))
+	default:
+		respondError(w, http.StatusBadRequest, "invalid request")
+	}
+	return false
+}
+
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
 
+// UpdateTask replaces labels and merges metadata; a null value deletes a
+// metadata key.
+func (h *TaskHandler) UpdateTask(w http.ResponseWriter, r *http.Request) {
+	taskID, ok := h.taskID(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Labels   *[]string          `json:"labels"`
+		Metadata map[string]*string `json:"metadata"`
+	}
+	if !decodeJSON(w, r, &request, false) {
+		return
+	}
+
+	task, err := h.manager.UpdateTask(taskID, service.TaskUpdate{Labels: request.Labels, Metadata: request.Metadata})
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrTaskNotFound):
+			respondError(w, http.StatusNotFound, "task not found")
+		case errors.Is(err, service.ErrInvalidLabel):
+			respondError(w, http.StatusBadRequest, "invalid label")
+		case errors.Is(err, service.ErrInvalidMetadata):
+			respondError(w, http.StatusBadRequest, "invalid metadata")
+		default:
+			respondError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+
+	task.Mu.Lock()
+	response := struct {
+		Labels   []string          `json:"labels"`
+		Metadata map[string]string `json:"metadata"`
+	}{
+		Labels:   slices.Clone(task.Labels),
+		Metadata: maps.Clone(task.Metadata),
+	}
+	task.Mu.Unlock()
+	respondJSON(w, http.StatusOK, response)
+}
+
+func (h *TaskHandler) DeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAdmin(w, r) {
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string][]service.DeadLetter{"dead_letters": h.manager.DeadLetters()})
+}
+
+func (h *TaskHandler) ListProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, def := h.manager.Profiles()
+	respondJSON(w, http.StatusOK, struct {
+		Default  string                           `json:"default,omitempty"`
+		Profiles map[string]internal.TaskSettings `json:"profiles"`
+	}{def, profiles})
+}
+
+// taskID rejects malformed IDs with 400 and other tenants' tasks with 404.
+func (h *TaskHandler) taskID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id, ok := h.pathID(w, r, "invalid task id")
+	if t := tenant(r); ok && t != service.AllTenants {
+		if _, err := h.manager.GetTaskFor(t, id); err != nil {
+			respondError(w, http.StatusNotFound, "task not found")
+			return "", false
+		}
+	}
+	return id, ok
+}
+
+func (h *TaskHandler) scheduleID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id, ok := h.pathID(w, r, "invalid schedule id")
+	if t := tenant(r); ok && t != service.AllTenants {
+		if _, err := h.manager.GetScheduleFor(t, id); err != nil {
+			respondError(w, http.StatusNotFound, "schedule not found")
+			return "", false
+		}
+	}
+	return id, ok
+}
+
+func (h *TaskHandler) pathID(w http.ResponseWriter, r *http.Request, message string) (string, bool) {
+	id := r.PathValue("id")
+	if !h.manager.ValidID(id) {
+		respondError(w, http.StatusBadRequest, message)
+		return "", false
+	}
+	return id, true
+}
+
+// Drain answers writes with 503 once the manager is shutting down. Reads keep
+// working, so clients can still poll status and fetch finished archives.
+func (h *TaskHandler) Drain(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if h.manager.Draining() {
+				w.Header().Set("Retry-After", "30")
+				respondError(w, http.StatusServiceUnavailable, "server shutting down")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func respondError(w http.ResponseWriter, status int, message string) {
 	respondJSON(w, status, map[string]string{"error": message})
 }
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	IdempotencyHeader = "Idempotency-Key"
+	maxIdempotencyKey = 255
+)
+
+// Idempotency replays the stored response when a mutating request is retried
+// with the same Idempotency-Key header. Reusing a key with a different
+// request answers 409.
+type Idempotency struct {
+	ttl time.Duration
+
+	entries   map[string]*idemEntry
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+type idemEntry struct {
+	fingerprint string
+	done        bool
+	status      int
+	header      http.Header
+	body        []byte
+	expires     time.Time
+}
+
+func NewIdempotency(ttl time.Duration) *Idempotency {
+	return &Idempotency{
+		ttl:     ttl,
+		entries: make(map[string]*idemEntry),
+	}
+}
+
+func (s *Idempotency) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			respondError(w, http.StatusBadRequest, "idempotency key too long")
+			return
+		}
+		// Callers with different API keys never share entries.
+		if c, ok := caller(r); ok {
+			key = c.ID + "\x00" + key
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				respondError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			} else {
+				respondError(w, http.StatusBadRequest, "invalid request")
+			}
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		entry, replay, err := s.begin(key, fingerprint)
+		if err != nil {
+			respondError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if replay {
+			for k, v := range entry.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(entry.status)
+			w.Write(entry.body)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			if p := recover(); p != nil {
+				s.abort(key)
+				panic(p)
+			}
+		}()
+		next(rec, r)
+		s.finish(key, entry, rec)
+	}
+}
+
+var (
+	errKeyReused     = errors.New("idempotency key reused with a different request")
+	errKeyInProgress = errors.New("request with this idempotency key is in progress")
+)
+
+func (s *Idempotency) begin(key, fingerprint string) (*idemEntry, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.prune(now)
+
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expires) {
+		if entry.fingerprint != fingerprint {
+			return nil, false, errKeyReused
+		}
+		if !entry.done {
+			return nil, false, errKeyInProgress
+		}
+		return entry, true, nil
+	}
+
+	entry := &idemEntry{fingerprint: fingerprint, expires: now.Add(s.ttl)}
+	s.entries[key] = entry
+	return entry, false, nil
+}
+
+// finish stores the response. Server errors and 429s are not stored so the
+// client can retry them with the same key.
+func (s *Idempotency) finish(key string, entry *idemEntry, rec *recorder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec.status >= 500 || rec.status == http.StatusTooManyRequests {
+		delete(s.entries, key)
+		return
+	}
+	entry.done = true
+	entry.status = rec.status
+	entry.header = rec.Header().Clone()
+	entry.body = rec.body.Bytes()
+}
+
+func (s *Idempotency) abort(key string) {
+	s.mu.Lock()
+	delete(s.entries, key)
+	s.mu.Unlock()
+}
+
+// prune must be called with mu held.
+func (s *Idempotency) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < time.Minute {
+		return
+	}
+	s.lastPrune = now
+	for key, entry := range s.entries {
+		if entry.done && now.After(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"test_ex_zip/internal"
+	"test_ex_zip/internal/ratelimit"
+	"time"
+)
+
+const (
+	BucketCreate   = "create"
+	BucketAdd      = "add"
+	BucketDownload = "download"
+)
+
+// RateLimiter throttles each client per group of endpoints. The buckets live
+// in store, so several instances can share them.
+type RateLimiter struct {
+	store  ratelimit.Store
+	limits map[string]limitPolicy
+}
+
+type limitPolicy struct {
+	limit  ratelimit.Limit
+	period time.Duration
+	quota  int
+}
+
+func NewRateLimiter(cfg internal.RateLimitConfig, store ratelimit.Store) (*RateLimiter, error) {
+	l := &RateLimiter{store: store, limits: make(map[string]limitPolicy)}
+	for bucket, c := range map[string]internal.RateLimit{
+		BucketCreate:   cfg.Create,
+		BucketAdd:      cfg.Add,
+		BucketDownload: cfg.Download,
+	} {
+		if c.Requests == 0 {
+			continue
+		}
+		period, err := c.MakeTimePeriod()
+		if err != nil {
+			return nil, fmt.Errorf("rate_limit.%s.period: %w", bucket, err)
+		}
+		burst := c.Burst
+		if burst == 0 {
+			burst = c.Requests
+		}
+		l.limits[bucket] = limitPolicy{
+			limit:  ratelimit.Limit{Rate: float64(c.Requests) / period.Seconds(), Burst: burst},
+			period: period,
+			quota:  c.Requests,
+		}
+	}
+	return l, nil
+}
+
+// Wrap answers 429 once the client has used up its bucket and reports the
+// state of the bucket in RateLimit-* headers. Run it inside Authenticate so
+// that clients with an API key are counted by key.
+func (l *RateLimiter) Wrap(bucket string, next http.HandlerFunc) http.HandlerFunc {
+	policy, ok := l.limits[bucket]
+	if !ok {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := "ip:" + clientIP(r)
+		if key, ok := caller(r); ok {
+			client = "key:" + key.ID
+		}
+
+		d, err := l.store.Take(r.Context(), bucket+"|"+client, policy.limit, time.Now())
+		if err != nil {
+			// Failing open keeps the API up when a shared store is down.
+			log.Printf("Rate limit store failed: %v", err)
+			next(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", policy.quota, int(policy.period.Seconds()), policy.limit.Burst))
+		h.Set("RateLimit-Limit", strconv.Itoa(policy.limit.Burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		h.Set("RateLimit-Reset", ceilSeconds(d.Reset))
+		if !d.Allowed {
+			h.Set("Retry-After", ceilSeconds(d.RetryAfter))
+			respondError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"test_ex_zip/internal"
+	"test_ex_zip/internal/service"
+)
+
+func (h *TaskHandler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	var request service.Schedule
+	if !decodeJSON(w, r, &request, false) {
+		return
+	}
+	for _, file := range request.Template.Files {
+		if file.Size < 0 {
+			respondError(w, http.StatusBadRequest, "invalid request")
+			return
+		}
+	}
+	var ok bool
+	if request.Template.Owner, ok = owner(w, r, request.Template.Owner); !ok {
+		return
+	}
+	if request.Template.LegalHold && !h.requireAdmin(w, r) {
+		return
+	}
+	request.Template.CreatedBy = callerID(r)
+
+	schedule, err := h.manager.CreateSchedule(request)
+	if err != nil {
+		var batch *service.BatchError
+		switch {
+		case errors.As(err, &batch):
+			respondFileErrors(w, batch)
+		case errors.Is(err, service.ErrProfileDenied):
+			respondError(w, http.StatusForbidden, err.Error())
+		case errors.Is(err, service.ErrInvalidSchedule), errors.Is(err, service.ErrInvalidCron):
+			respondError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, internal.ErrInvalidPolicy):
+			respondError(w, http.StatusBadRequest, "invalid failure policy")
+		case errors.Is(err, service.ErrInvalidLabel):
+			respondError(w, http.StatusBadRequest, "invalid label")
+		case errors.Is(err, service.ErrInvalidMetadata):
+			respondError(w, http.StatusBadRequest, "invalid metadata")
+		case errors.Is(err, service.ErrInvalidCallback):
+			respondError(w, http.StatusBadRequest, "invalid callback url")
+		case errors.Is(err, internal.ErrUnknownProfile), errors.Is(err, internal.ErrInvalidOverride):
+			respondError(w, http.StatusBadRequest, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, "internal error")
+		}
+		return
+	}
+	respondJSON(w, http.StatusCreated, schedule)
+}
+
+func (h *TaskHandler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, map[string][]service.Schedule{"schedules": h.manager.ListSchedules(tenant(r))})
+}
+
+// GetSchedule adds the current status and archive link of every task in the
+// run history. Tasks already cleaned up have no status.
+func (h *TaskHandler) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.scheduleID(w, r)
+	if !ok {
+		return
+	}
+	schedule, err := h.manager.GetSchedule(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "schedule not found")
+		return
+	}
+
+	type run struct {
+		service.ScheduleRun
+		Status  internal.TaskStatus `json:"status,omitempty"`
+		Archive string              `json:"archive,omitempty"`
+	}
+	history := make([]run, len(schedule.History))
+	for i, entry := range schedule.History {
+		history[i].ScheduleRun = entry
+		if entry.TaskID == "" {
+			continue
+		}
+		task, err := h.manager.GetTask(entry.TaskID)
+		if err != nil {
+			continue
+		}
+		task.Mu.Lock()
+		history[i].Status = task.Status
+		hasArchive := task.HasArchive()
+		task.Mu.Unlock()
+		if hasArchive {
+			if link, err := h.manager.DownloadLink(entry.TaskID, service.LinkOptions{}); err == nil {
+				history[i].Archive = link.URL
+			}
+		}
+	}
+
+	respondJSON(w, http.StatusOK, struct {
+		service.Schedule
+		History []run `json:"history"`
+	}{
+		Schedule: schedule,
+		History:  history,
+	})
+}
+
+func (h *TaskHandler) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.scheduleID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.manager.DeleteSchedule(id); err != nil {
+		respondError(w, http.StatusNotFound, "schedule not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *TaskHandler) PauseSchedule(w http.ResponseWriter, r *http.Request) {
+	h.setSchedulePaused(w, r, true)
+}
+
+func (h *TaskHandler) ResumeSchedule(w http.ResponseWriter, r *http.Request) {
+	h.setSchedulePaused(w, r, false)
+}
+
+func (h *TaskHandler) setSchedulePaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	id, ok := h.scheduleID(w, r)
+	if !ok {
+		return
+	}
+	schedule, err := h.manager.PauseSchedule(id, paused)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "schedule not found")
+		return
+	}
+	respondJSON(w, http.StatusOK, schedule)
+}
+
+// RunSchedule triggers a run right away. The run is recorded in the history
+// even when the task could not be created.
+func (h *TaskHandler) RunSchedule(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.scheduleID(w, r)
+	if !ok {
+		return
+	}
+	run, err := h.manager.TriggerSchedule(id)
+	var quota *service.QuotaError
+	switch {
+	case err == nil:
+		respondJSON(w, http.StatusCreated, run)
+	case errors.Is(err, service.ErrScheduleNotFound):
+		respondError(w, http.StatusNotFound, "schedule not found")
+	case errors.As(err, &quota):
+		retryAfter(w, quota.ResetAt)
+		respondJSON(w, http.StatusTooManyRequests, run)
+	case errors.Is(err, service.ErrProfileDenied):
+		respondJSON(w, http.StatusForbidden, run)
+	case errors.Is(err, service.ErrServerBusy):
+		respondJSON(w, http.StatusTooManyRequests, run)
+	case errors.Is(err, service.ErrInsufficientStorage):
+		respondJSON(w, http.StatusInsufficientStorage, run)
+	case errors.Is(err, service.ErrShuttingDown):
+		respondJSON(w, http.StatusServiceUnavailable, run)
+	default:
+		respondJSON(w, http.StatusInternalServerError, run)
+	}
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"test_ex_zip/internal"
+	"time"
+)
+
+// NewServer applies the http section of the config: timeouts, header and
+// body limits and, when configured, TLS with optional client certificates.
+func NewServer(cfg *internal.Config, handler http.Handler) (*http.Server, error) {
+	c := cfg.HTTP
+	srv := &http.Server{
+		Addr:           cfg.Addr,
+		Handler:        limitBody(handler, c.MaxBodyBytes),
+		MaxHeaderBytes: c.MaxHeaderBytes,
+	}
+	var err error
+	if srv.ReadHeaderTimeout, err = c.MakeTimeReadHeader(); err != nil {
+		return nil, err
+	}
+	if srv.ReadTimeout, err = c.MakeTimeRead(); err != nil {
+		return nil, err
+	}
+	if srv.WriteTimeout, err = c.MakeTimeWrite(); err != nil {
+		return nil, err
+	}
+	if srv.IdleTimeout, err = c.MakeTimeIdle(); err != nil {
+		return nil, err
+	}
+
+	if c.TLS.Enabled() {
+		certs := &certReloader{certFile: c.TLS.CertFile, keyFile: c.TLS.KeyFile, caFile: c.TLS.ClientCAFile}
+		if err := certs.load(); err != nil {
+			return nil, err
+		}
+		srv.TLSConfig = certs.config(c.TLS.ClientAuth)
+	}
+	return srv, nil
+}
+
+// Listen binds the server address. Requests made before Serve starts wait
+// in the accept queue instead of being refused.
+func Listen(srv *http.Server) (net.Listener, error) {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":http"
+		if srv.TLSConfig != nil {
+			addr = ":https"
+		}
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Serve serves TLS on ln when NewServer configured it.
+func Serve(srv *http.Server, ln net.Listener) error {
+	if srv.TLSConfig != nil {
+		return srv.ServeTLS(ln, "", "")
+	}
+	return srv.Serve(ln)
+}
+
+func limitBody(next http.Handler, limit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clearWriteDeadline lifts write_timeout for responses that legitimately run
+// long: archive downloads, event streams and long polls.
+func clearWriteDeadline(w http.ResponseWriter) {
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
+
+// certReloader re-reads the certificate, key and client CA files when one of
+// them changes, so renewed certificates apply without a restart. A broken
+// update is logged and the previous files stay in use.
+type certReloader struct {
+	certFile, keyFile, caFile string
+
+	mu        sync.Mutex
+	stamp     string
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func (c *certReloader) config(clientAuth string) *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := c.current()
+			return cert, nil
+		},
+	}
+	if c.caFile == "" {
+		return base
+	}
+
+	base.ClientAuth = tls.RequireAndVerifyClientCert
+	if clientAuth == internal.ClientAuthOptional {
+		base.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		_, pool := c.current()
+		cfg := base.Clone()
+		cfg.ClientCAs = pool
+		return cfg, nil
+	}
+	return base
+}
+
+func (c *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stamp, err := c.fileStamp(); err == nil && stamp != c.stamp {
+		if err := c.loadLocked(); err != nil {
+			log.Printf("Keeping the current TLS certificate: %v", err)
+			c.stamp = stamp
+		} else {
+			log.Printf("TLS certificate reloaded")
+		}
+	}
+	return c.cert, c.clientCAs
+}
+
+func (c *certReloader) load() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.loadLocked()
+}
+
+func (c *certReloader) loadLocked() error {
+	stamp, err := c.fileStamp()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("http.tls: %w", err)
+	}
+	var pool *x509.CertPool
+	if c.caFile != "" {
+		pem, err := os.ReadFile(c.caFile)
+		if err != nil {
+			return fmt.Errorf("http.tls.client_ca_file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("http.tls.client_ca_file: no certificates found")
+		}
+	}
+	c.stamp, c.cert, c.clientCAs = stamp, &cert, pool
+	return nil
+}
+
+// fileStamp changes whenever one of the files is rewritten.
+func (c *certReloader) fileStamp() (string, error) {
+	var stamp string
+	for _, path := range []string{c.certFile, c.keyFile, c.caFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%d/%d;", info.ModTime().UnixNano(), info.Size())
+	}
+	return stamp, nil
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+type FailurePolicy string
+
+const (
+	PolicyAllOrNothing FailurePolicy = "all_or_nothing"
+	PolicyBestEffort   FailurePolicy = "best_effort"
+
+	minSuccessPrefix = "min_success="
+)
+
+var ErrInvalidPolicy = errors.New("invalid failure policy")
+
+func ParseFailurePolicy(s string) (FailurePolicy, error) {
+	p := FailurePolicy(strings.TrimSpace(s))
+	switch p {
+	case "":
+		return PolicyBestEffort, nil
+	case PolicyAllOrNothing, PolicyBestEffort:
+		return p, nil
+	}
+	if _, err := p.minSuccess(); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+func (p FailurePolicy) minSuccess() (int, error) {
+	if !strings.HasPrefix(string(p), minSuccessPrefix) {
+		return 0, ErrInvalidPolicy
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(string(p), minSuccessPrefix))
+	if err != nil || n < 1 {
+		return 0, ErrInvalidPolicy
+	}
+	return n, nil
+}
+
+// Outcome maps the number of successfully fetched files to the final task status.
+func (p FailurePolicy) Outcome(succeeded, total int) TaskStatus {
+	if succeeded == 0 {
+		return StatusFailed
+	}
+	if succeeded == total {
+		return StatusCompleted
+	}
+
+	switch p {
+	case PolicyAllOrNothing:
+		return StatusFailed
+	case PolicyBestEffort, "":
+		return StatusPartial
+	}
+	n, err := p.minSuccess()
+	if err != nil || succeeded < n {
+		return StatusFailed
+	}
+	return StatusPartial
+}
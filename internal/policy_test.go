@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseFailurePolicy(t *testing.T) {
+	tests := []struct {
+		in   string
+		want FailurePolicy
+		err  bool
+	}{
+		{in: "", want: PolicyBestEffort},
+		{in: "  ", want: PolicyBestEffort},
+		{in: "best_effort", want: PolicyBestEffort},
+		{in: "all_or_nothing", want: PolicyAllOrNothing},
+		{in: " all_or_nothing ", want: PolicyAllOrNothing},
+		{in: "min_success=1", want: "min_success=1"},
+		{in: "min_success=25", want: "min_success=25"},
+		{in: "min_success=0", err: true},
+		{in: "min_success=-2", err: true},
+		{in: "min_success=", err: true},
+		{in: "min_success=two", err: true},
+		{in: "min_success", err: true},
+		{in: "ALL_OR_NOTHING", err: true},
+		{in: "some", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFailurePolicy(tt.in)
+		if tt.err {
+			if !errors.Is(err, ErrInvalidPolicy) {
+				t.Errorf("ParseFailurePolicy(%q) error = %v, want ErrInvalidPolicy", tt.in, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseFailurePolicy(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestFailurePolicyOutcome(t *testing.T) {
+	tests := []struct {
+		policy           FailurePolicy
+		succeeded, total int
+		want             TaskStatus
+	}{
+		{PolicyBestEffort, 3, 3, StatusCompleted},
+		{PolicyBestEffort, 1, 3, StatusPartial},
+		{PolicyBestEffort, 0, 3, StatusFailed},
+		{"", 2, 3, StatusPartial},
+		{PolicyAllOrNothing, 3, 3, StatusCompleted},
+		{PolicyAllOrNothing, 2, 3, StatusFailed},
+		{PolicyAllOrNothing, 0, 3, StatusFailed},
+		{"min_success=2", 3, 3, StatusCompleted},
+		{"min_success=2", 2, 3, StatusPartial},
+		{"min_success=2", 1, 3, StatusFailed},
+		{"min_success=2", 0, 3, StatusFailed},
+		// min_success above the number of files can only be met by
+		// fetching every file.
+		{"min_success=5", 3, 3, StatusCompleted},
+		{"min_success=5", 2, 3, StatusFailed},
+		{"min_success=5", 0, 3, StatusFailed},
+		{"min_success=1", 1, 1, StatusCompleted},
+		{"bogus", 1, 3, StatusFailed},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.Outcome(tt.succeeded, tt.total); got != tt.want {
+			t.Errorf("%q.Outcome(%d, %d) = %q, want %q", tt.policy, tt.succeeded, tt.total, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package internal
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+const (
+	CompressionDeflate = "deflate"
+	CompressionStore   = "store"
+)
+
+var (
+	ErrUnknownProfile  = errors.New("unknown profile")
+	ErrInvalidOverride = errors.New("invalid override")
+)
+
+// Profile is a named set of task limits. Empty fields fall back to the
+// top-level config values. Limits caps what a task may override; an empty
+// limit lets tasks only lower the profile value.
+type Profile struct {
+	MaxFiles     int           `json:"max_files"`
+	AllowedExts  []string      `json:"allowed_exts"`
+	PrTimeout    string        `json:"processing_timeout"`
+	DwnTimeout   string        `json:"download_timeout"`
+	Compression  string        `json:"compression"`
+	Retries      int           `json:"retries"`
+	RetryBackoff string        `json:"retry_backoff"`
+	Limits       ProfileLimits `json:"limits"`
+}
+
+type ProfileLimits struct {
+	MaxFiles   int    `json:"max_files"`
+	PrTimeout  string `json:"processing_timeout"`
+	DwnTimeout string `json:"download_timeout"`
+	Retries    int    `json:"retries"`
+}
+
+// ProfileOverrides are the per-task changes to the chosen profile.
+type ProfileOverrides struct {
+	MaxFiles    int      `json:"max_files,omitempty"`
+	AllowedExts []string `json:"allowed_exts,omitempty"`
+	PrTimeout   string   `json:"processing_timeout,omitempty"`
+	DwnTimeout  string   `json:"download_timeout,omitempty"`
+	Compression string   `json:"compression,omitempty"`
+	Retries     *int     `json:"retries,omitempty"`
+}
+
+// TaskSettings are the limits a task runs with, resolved from its profile
+// and overrides when the task is created.
+type TaskSettings struct {
+	Profile      string
+	MaxFiles     int
+	AllowedExts  []string
+	PrTimeout    time.Duration
+	DwnTimeout   time.Duration
+	Compression  string
+	Retries      int
+	RetryBackoff time.Duration
+}
+
+// taskSettingsJSON spells durations the way the config does.
+type taskSettingsJSON struct {
+	Profile      string   `json:"profile,omitempty"`
+	MaxFiles     int      `json:"max_files"`
+	AllowedExts  []string `json:"allowed_exts"`
+	PrTimeout    string   `json:"processing_timeout"`
+	DwnTimeout   string   `json:"download_timeout"`
+	Compression  string   `json:"compression"`
+	Retries      int      `json:"retries"`
+	RetryBackoff string   `json:"retry_backoff"`
+}
+
+func (s TaskSettings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(taskSettingsJSON{
+		Profile:      s.Profile,
+		MaxFiles:     s.MaxFiles,
+		AllowedExts:  s.AllowedExts,
+		PrTimeout:    s.PrTimeout.String(),
+		DwnTimeout:   s.DwnTimeout.String(),
+		Compression:  s.Compression,
+		Retries:      s.Retries,
+		RetryBackoff: s.RetryBackoff.String(),
+	})
+}
+
+func (s *TaskSettings) UnmarshalJSON(data []byte) error {
+	var raw taskSettingsJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*s = TaskSettings{
+		Profile:     raw.Profile,
+		MaxFiles:    raw.MaxFiles,
+		AllowedExts: raw.AllowedExts,
+		Compression: raw.Compression,
+		Retries:     raw.Retries,
+	}
+	var err error
+	if s.PrTimeout, err = time.ParseDuration(raw.PrTimeout); err != nil {
+		return err
+	}
+	if s.DwnTimeout, err = time.ParseDuration(raw.DwnTimeout); err != nil {
+		return err
+	}
+	s.RetryBackoff, err = time.ParseDuration(raw.RetryBackoff)
+	return err
+}
+
+// ZipMethod is the archive entry compression method.
+func (s TaskSettings) ZipMethod() uint16 {
+	if s.Compression == CompressionStore {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// Settings resolves a profile, or default_profile when name is empty, and
+// applies the overrides within the profile limits.
+func (c *Config) Settings(name string, o ProfileOverrides) (TaskSettings, error) {
+	if name == "" {
+		name = c.DefaultProfile
+	}
+	var p Profile
+	if name != "" {
+		var ok bool
+		if p, ok = c.Profiles[name]; !ok {
+			return TaskSettings{}, fmt.Errorf("%w: %q", ErrUnknownProfile, name)
+		}
+	}
+
+	s, err := c.baseSettings(name, p)
+	if err != nil {
+		return TaskSettings{}, err
+	}
+	maxFiles, prTimeout, dwnTimeout, retries, err := p.limits(s)
+	if err != nil {
+		return TaskSettings{}, err
+	}
+
+	if o.MaxFiles != 0 {
+		if o.MaxFiles < 0 || o.MaxFiles > maxFiles {
+			return TaskSettings{}, fmt.Errorf("%w: max_files must be 1-%d", ErrInvalidOverride, maxFiles)
+		}
+		s.MaxFiles = o.MaxFiles
+	}
+	if o.AllowedExts != nil {
+		for _, ext := range o.AllowedExts {
+			if !slices.Contains(s.AllowedExts, ext) {
+				return TaskSettings{}, fmt.Errorf("%w: extension %q not allowed", ErrInvalidOverride, ext)
+			}
+		}
+		s.AllowedExts = slices.Clone(o.AllowedExts)
+	}
+	if o.PrTimeout != "" {
+		if s.PrTimeout, err = boundedDuration("processing_timeout", o.PrTimeout, prTimeout); err != nil {
+			return TaskSettings{}, err
+		}
+	}
+	if o.DwnTimeout != "" {
+		if s.DwnTimeout, err = boundedDuration("download_timeout", o.DwnTimeout, dwnTimeout); err != nil {
+			return TaskSettings{}, err
+		}
+	}
+	if o.Compression != "" {
+		if !validCompression(o.Compression) {
+			return TaskSettings{}, fmt.Errorf("%w: unknown compression %q", ErrInvalidOverride, o.Compression)
+		}
+		s.Compression = o.Compression
+	}
+	if o.Retries != nil {
+		if *o.Retries < 0 || *o.Retries > retries {
+			return TaskSettings{}, fmt.Errorf("%w: retries must be 0-%d", ErrInvalidOverride, retries)
+		}
+		s.Retries = *o.Retries
+	}
+	return s, nil
+}
+
+func (c *Config) baseSettings(name string, p Profile) (TaskSettings, error) {
+	s := TaskSettings{
+		Profile:     name,
+		MaxFiles:    c.MaxFiles,
+		AllowedExts: c.AllowedExts,
+		Compression: CompressionDeflate,
+		Retries:     p.Retries,
+	}
+	var err error
+	if s.PrTimeout, err = c.MakeTimePr(); err != nil {
+		return TaskSettings{}, err
+	}
+	if s.DwnTimeout, err = c.MakeTimeDwn(); err != nil {
+		return TaskSettings{}, err
+	}
+	if s.RetryBackoff, err = parseOptionalDuration(p.RetryBackoff, time.Second); err != nil {
+		return TaskSettings{}, fmt.Errorf("retry_backoff: %w", err)
+	}
+
+	if p.MaxFiles != 0 {
+		s.MaxFiles = p.MaxFiles
+	}
+	if p.AllowedExts != nil {
+		s.AllowedExts = p.AllowedExts
+	}
+	if p.PrTimeout != "" {
+		if s.PrTimeout, err = time.ParseDuration(p.PrTimeout); err != nil {
+			return TaskSettings{}, fmt.Errorf("processing_timeout: %w", err)
+		}
+	}
+	if p.DwnTimeout != "" {
+		if s.DwnTimeout, err = time.ParseDuration(p.DwnTimeout); err != nil {
+			return TaskSettings{}, fmt.Errorf("download_timeout: %w", err)
+		}
+	}
+	if p.Compression != "" {
+		if !validCompression(p.Compression) {
+			return TaskSettings{}, fmt.Errorf("unknown compression %q", p.Compression)
+		}
+		s.Compression = p.Compression
+	}
+	if s.MaxFiles <= 0 || s.Retries < 0 || s.PrTimeout <= 0 || s.DwnTimeout <= 0 {
+		return TaskSettings{}, errors.New("limits must be positive")
+	}
+	s.AllowedExts = slices.Clone(s.AllowedExts)
+	return s, nil
+}
+
+// limits returns the highest values a task may override to.
+func (p *Profile) limits(s TaskSettings) (maxFiles int, prTimeout, dwnTimeout time.Duration, retries int, err error) {
+	maxFiles, prTimeout, dwnTimeout, retries = s.MaxFiles, s.PrTimeout, s.DwnTimeout, s.Retries
+	if p.Limits.MaxFiles > maxFiles {
+		maxFiles = p.Limits.MaxFiles
+	}
+	if p.Limits.Retries > retries {
+		retries = p.Limits.Retries
+	}
+	if p.Limits.PrTimeout != "" {
+		d, err := time.ParseDuration(p.Limits.PrTimeout)
+		if err != nil {
+			return 0, 0, 0, 0, fmt.Errorf("limits.processing_timeout: %w", err)
+		}
+		prTimeout = max(prTimeout, d)
+	}
+	if p.Limits.DwnTimeout != "" {
+		d, err := time.ParseDuration(p.Limits.DwnTimeout)
+		if err != nil {
+			return 0, 0, 0, 0, fmt.Errorf("limits.download_timeout: %w", err)
+		}
+		dwnTimeout = max(dwnTimeout, d)
+	}
+	return maxFiles, prTimeout, dwnTimeout, retries, nil
+}
+
+func boundedDuration(field, value string, limit time.Duration) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 || d > limit {
+		return 0, fmt.Errorf("%w: %s must be positive and at most %s", ErrInvalidOverride, field, limit)
+	}
+	return d, nil
+}
+
+func validCompression(s string) bool {
+	return s == CompressionDeflate || s == CompressionStore
+}
+
+// validateProfiles checks the fields of every profile so that mistakes
+// surface at startup instead of on the first task.
+func (c *Config) validateProfiles(v *validator) {
+	if c.DefaultProfile != "" {
+		_, ok := c.Profiles[c.DefaultProfile]
+		v.check(ok, "default_profile: unknown profile %q", c.DefaultProfile)
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Profiles)) {
+		p, field := c.Profiles[name], "profiles."+name
+		v.check(p.MaxFiles >= 0, "%s.max_files: must not be negative", field)
+		for _, ext := range p.AllowedExts {
+			v.check(validExt(ext), "%s.allowed_exts: %q must look like \".pdf\"", field, ext)
+		}
+		v.duration(field+".processing_timeout", p.PrTimeout, false)
+		v.duration(field+".download_timeout", p.DwnTimeout, false)
+		v.check(p.Compression == "" || validCompression(p.Compression),
+			"%s.compression: must be %q or %q, got %q", field, CompressionDeflate, CompressionStore, p.Compression)
+		v.check(p.Retries >= 0, "%s.retries: must not be negative", field)
+		v.duration(field+".retry_backoff", p.RetryBackoff, false)
+		v.check(p.Limits.MaxFiles >= 0, "%s.limits.max_files: must not be negative", field)
+		v.check(p.Limits.Retries >= 0, "%s.limits.retries: must not be negative", field)
+		v.duration(field+".limits.processing_timeout", p.Limits.PrTimeout, false)
+		v.duration(field+".limits.download_timeout", p.Limits.DwnTimeout, false)
+	}
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+func TestBucketBurst(t *testing.T) {
+	limit := Limit{Rate: 1, Burst: 3}
+	var b Bucket
+	for i := 2; i >= 0; i-- {
+		d := b.Take(limit, start)
+		if !d.Allowed || d.Remaining != i || d.RetryAfter != 0 {
+			t.Fatalf("take %d: %+v, want allowed with %d remaining", 3-i, d, i)
+		}
+	}
+	if d := b.Take(limit, start); d.Allowed || d.Remaining != 0 {
+		t.Fatalf("take past burst: %+v, want refused", d)
+	}
+}
+
+func TestBucketRetryAfter(t *testing.T) {
+	limit := Limit{Rate: 2, Burst: 1}
+	var b Bucket
+	b.Take(limit, start)
+
+	d := b.Take(limit, start)
+	if d.Allowed || d.RetryAfter != 500*time.Millisecond || d.Reset != 500*time.Millisecond {
+		t.Fatalf("empty bucket: %+v, want retry after and reset 500ms", d)
+	}
+	d = b.Take(limit, start.Add(200*time.Millisecond))
+	if d.Allowed || d.RetryAfter != 300*time.Millisecond {
+		t.Fatalf("partly refilled: %+v, want retry after 300ms", d)
+	}
+	if d = b.Take(limit, start.Add(500*time.Millisecond)); !d.Allowed {
+		t.Fatalf("after retry after: %+v, want allowed", d)
+	}
+}
+
+func TestBucketRefill(t *testing.T) {
+	limit := Limit{Rate: 0.5, Burst: 4}
+	var b Bucket
+	for range 4 {
+		b.Take(limit, start)
+	}
+
+	tests := []struct {
+		after     time.Duration
+		allowed   bool
+		remaining int
+	}{
+		{after: time.Second, allowed: false},
+		{after: 2 * time.Second, allowed: true, remaining: 0},
+		{after: 3 * time.Second, allowed: false},
+		{after: 8 * time.Second, allowed: true, remaining: 2},
+		// Refill stops at the burst.
+		{after: time.Hour, allowed: true, remaining: 3},
+		// A clock going backwards does not refill.
+		{after: time.Minute, allowed: true, remaining: 2},
+	}
+	for _, tt := range tests {
+		d := b.Take(limit, start.Add(tt.after))
+		if d.Allowed != tt.allowed || d.Remaining != tt.remaining {
+			t.Errorf("take at +%v: %+v, want allowed %v with %d remaining", tt.after, d, tt.allowed, tt.remaining)
+		}
+	}
+}
+
+func TestMemoryStoreKeys(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	limit := Limit{Rate: 1, Burst: 2}
+
+	for range 2 {
+		if d, _ := s.Take(ctx, "a", limit, start); !d.Allowed {
+			t.Fatalf("a within burst: %+v", d)
+		}
+	}
+	if d, _ := s.Take(ctx, "a", limit, start); d.Allowed {
+		t.Fatalf("a past burst: %+v, want refused", d)
+	}
+	if d, _ := s.Take(ctx, "b", limit, start); !d.Allowed || d.Remaining != 1 {
+		t.Fatalf("b after a is empty: %+v, want allowed with 1 remaining", d)
+	}
+	if d, _ := s.Take(ctx, "a", limit, start.Add(time.Second)); !d.Allowed || d.Remaining != 0 {
+		t.Fatalf("a after refill: %+v, want allowed with 0 remaining", d)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	fast := Limit{Rate: 1, Burst: 2}
+	slow := Limit{Rate: 0.01, Burst: 2}
+
+	s.Take(ctx, "idle", fast, start)
+	s.Take(ctx, "busy", slow, start)
+	s.Take(ctx, "busy", slow, start)
+
+	// A minute on, "idle" has refilled and is dropped, while "busy" is still
+	// short of full and keeps its state.
+	d, _ := s.Take(ctx, "busy", slow, start.Add(sweepInterval))
+	if d.Allowed {
+		t.Errorf("busy after sweep: %+v, want refused", d)
+	}
+	if _, ok := s.buckets["idle"]; ok {
+		t.Error("full bucket was not swept")
+	}
+	if d, _ := s.Take(ctx, "idle", fast, start.Add(sweepInterval)); !d.Allowed || d.Remaining != 1 {
+		t.Errorf("swept key: %+v, want a fresh bucket", d)
+	}
+}
@@ -2,12 +2,20 @@ package service
 
 import (
 	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 )
 
-func CreateArchive(filePaths []string, fileNames []string, dest string) error {
+// errNotAttempted marks sources left unfetched after a streamed archive was aborted.
+var errNotAttempted = errors.New("not attempted: archive aborted")
+
+func CreateArchive(filePaths []string, fileNames []string, dest string, method uint16) error {
 	archive, err := os.Create(dest)
 	if err != nil {
 		return err
@@ -45,7 +53,7 @@ func CreateArchive(filePaths []string, fileNames []string, dest string) error {
 
 		header := &zip.FileHeader{
 			Name:   fileNames[i],
-			Method: zip.Deflate,
+			Method: method,
 		}
 
 		writer, err := zipWriter.CreateHeader(header)
@@ -60,3 +68,78 @@ func CreateArchive(filePaths []string, fileNames []string, dest string) error {
 
 	return nil
 }
+
+// StreamArchive writes a zip of the given URLs to w without staging them on disk.
+// Origins are fetched one by one; a source that fails before its entry is
+// started is skipped and reported in errs, while a failure in the middle of an
+// entry leaves a truncated archive and is returned as err, with every later
+// source reported in errs as not attempted.
+func StreamArchive(ctx context.Context, w io.Writer, urls []string, fileNames []string, method uint16, retry RetryPolicy, progress Progress) (errs []error, err error) {
+	zipWriter := zip.NewWriter(w)
+	errs = make([]error, len(urls))
+
+	for i, url := range urls {
+		var fatal bool
+		err := retry.do(ctx, func() (bool, error) {
+			if progress != nil {
+				progress.Begin(i)
+			}
+			var err error
+			fatal, err = streamEntry(ctx, zipWriter, i, url, fileNames[i], method, progress)
+			if progress != nil {
+				progress.End(i, err)
+			}
+			return !fatal && retryable(err), err
+		})
+		if err != nil {
+			errs[i] = fmt.Errorf("URL %s: %w", url, err)
+		}
+		if fatal {
+			for j := i + 1; j < len(urls); j++ {
+				errs[j] = errNotAttempted
+			}
+			return errs, err
+		}
+	}
+
+	return errs, zipWriter.Close()
+}
+
+// streamEntry copies one source into a new archive entry. An error is fatal
+// once the entry has been started, since the archive can no longer skip it.
+func streamEntry(ctx context.Context, zipWriter *zip.Writer, i int, url, name string, method uint16, progress Progress) (fatal bool, err error) {
+	body, total, err := openSource(ctx, url)
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+
+	header := &zip.FileHeader{
+		Name:   name,
+		Method: method,
+	}
+	writer, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return true, err
+	}
+	pw := newProgressWriter(writer, i, total, progress)
+	if _, err := io.Copy(pw, body); err != nil {
+		return true, err
+	}
+	pw.done()
+	return false, nil
+}
+
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr              string
+		minute, hour, dow uint64
+		invalid           bool
+	}{
+		{expr: "*/15 * * * *", minute: 1<<0 | 1<<15 | 1<<30 | 1<<45},
+		{expr: "5/20 9-11 * * *", minute: 1<<5 | 1<<25 | 1<<45, hour: 1<<9 | 1<<10 | 1<<11},
+		{expr: "0,30 1-5/2 * * *", minute: 1<<0 | 1<<30, hour: 1<<1 | 1<<3 | 1<<5},
+		{expr: "0 0 * * 7", dow: 1<<0 | 1<<7},
+		{expr: "0 0 * * 5-7", dow: 1<<0 | 1<<5 | 1<<6 | 1<<7},
+		{expr: "0 0 * * 1-5", dow: 1<<1 | 1<<2 | 1<<3 | 1<<4 | 1<<5},
+		{expr: "0 0 29 2 *"},
+		{expr: "0 0 30,31 2,3 *"},
+		{expr: "0 0 31 2 1"},
+		{expr: "0 0 * *", invalid: true},
+		{expr: "0 0 * * * *", invalid: true},
+		{expr: "60 * * * *", invalid: true},
+		{expr: "* 24 * * *", invalid: true},
+		{expr: "* * 0 * *", invalid: true},
+		{expr: "* * * 13 *", invalid: true},
+		{expr: "* * * * 8", invalid: true},
+		{expr: "5-1 * * * *", invalid: true},
+		{expr: "1- * * * *", invalid: true},
+		{expr: "*/0 * * * *", invalid: true},
+		{expr: "*/x * * * *", invalid: true},
+		{expr: "a * * * *", invalid: true},
+		{expr: "0 0 31 2 *", invalid: true},
+		{expr: "0 0 30,31 2 *", invalid: true},
+		{expr: "0 0 31 4,6,9,11 *", invalid: true},
+		{expr: "0 0 31 2 */2", invalid: true},
+	}
+	for _, tt := range tests {
+		spec, err := parseCron(tt.expr)
+		if tt.invalid {
+			if !errors.Is(err, ErrInvalidCron) {
+				t.Errorf("parseCron(%q) error = %v, want ErrInvalidCron", tt.expr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if tt.minute != 0 && spec.minute != tt.minute {
+			t.Errorf("parseCron(%q) minute = %b, want %b", tt.expr, spec.minute, tt.minute)
+		}
+		if tt.hour != 0 && spec.hour != tt.hour {
+			t.Errorf("parseCron(%q) hour = %b, want %b", tt.expr, spec.hour, tt.hour)
+		}
+		if tt.dow != 0 && spec.dow != tt.dow {
+			t.Errorf("parseCron(%q) dow = %b, want %b", tt.expr, spec.dow, tt.dow)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no tz database: %v", err)
+	}
+	// São Paulo skipped midnight when it still had DST.
+	sp, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Skipf("no tz database: %v", err)
+	}
+	utc := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	local := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, ny)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name       string
+		expr       string
+		from, want time.Time
+	}{
+		{"step", "*/15 * * * *", utc("2026-10-19 10:07"), utc("2026-10-19 10:15")},
+		{"strictly after", "30 10 * * *", utc("2026-10-19 10:30"), utc("2026-10-20 10:30")},
+		{"seconds truncated", "30 10 * * *", utc("2026-10-19 10:29").Add(59 * time.Second), utc("2026-10-19 10:30")},
+		{"weekdays skip weekend", "0 9 * * 1-5", utc("2026-10-16 10:00"), utc("2026-10-19 09:00")},
+		{"month rollover", "0 0 1 * *", utc("2026-12-15 00:00"), utc("2027-01-01 00:00")},
+		{"7 is sunday", "0 0 * * 7", utc("2026-10-19 00:00"), utc("2026-10-25 00:00")},
+		{"0 is sunday", "0 0 * * 0", utc("2026-10-19 00:00"), utc("2026-10-25 00:00")},
+		{"dom or dow, dow first", "0 0 13 * 5", utc("2026-10-01 00:00"), utc("2026-10-02 00:00")},
+		{"dom or dow, dom first", "0 0 13 * 5", utc("2026-10-10 00:00"), utc("2026-10-13 00:00")},
+		{"dom only", "0 0 13 * *", utc("2026-10-14 00:00"), utc("2026-11-13 00:00")},
+		{"dom step", "0 0 */2 * *", utc("2026-10-01 00:00"), utc("2026-10-03 00:00")},
+		{"dow step", "0 0 * * */2", utc("2026-10-19 00:00"), utc("2026-10-20 00:00")},
+		{"dom and dow step", "0 0 13 * */5", utc("2026-01-01 00:00"), utc("2026-02-13 00:00")},
+		{"leap day", "0 0 29 2 *", utc("2026-03-01 00:00"), utc("2028-02-29 00:00")},
+		{"31st skips short months", "0 0 31 * *", utc("2026-04-01 00:00"), utc("2026-05-31 00:00")},
+		{"dst gap steps over", "*/30 * * * *", local("2026-03-08 01:45"), local("2026-03-08 03:00")},
+		{"dst gap skips missing time", "30 2 * * *", local("2026-03-07 03:00"), local("2026-03-09 02:30")},
+		{"dst keeps wall clock", "0 9 * * *", local("2026-03-07 09:00"), local("2026-03-08 09:00")},
+		{"dst fall back", "0 3 * * *", local("2026-11-01 00:00"), local("2026-11-01 03:00")},
+		{"dst gap at midnight", "0 12 * * *", time.Date(2018, 11, 3, 12, 0, 0, 0, sp), time.Date(2018, 11, 4, 12, 0, 0, 0, sp)},
+	}
+	for _, tt := range tests {
+		spec, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: parseCron(%q): %v", tt.name, tt.expr, err)
+		}
+		if got := spec.next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%s: next(%q, %v) = %v, want %v", tt.name, tt.expr, tt.from, got, tt.want)
+		}
+	}
+
+	if d := local("2026-11-01 03:00").Sub(local("2026-11-01 00:00")); d != 4*time.Hour {
+		t.Errorf("fall back day: 00:00 to 03:00 is %v, want 4h", d)
+	}
+}
+
+func TestCronDayMatches(t *testing.T) {
+	monday := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	tuesday13 := time.Date(2026, 10, 13, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		expr string
+		day  time.Time
+		want bool
+	}{
+		{"0 0 * * *", monday, true},
+		{"0 0 19 * *", monday, true},
+		{"0 0 20 * *", monday, false},
+		{"0 0 * * 1", monday, true},
+		{"0 0 * * 2", monday, false},
+		{"0 0 13 * 1", monday, true},
+		{"0 0 13 * 1", tuesday13, true},
+		{"0 0 14 * 5", tuesday13, false},
+		{"0 0 */2 * *", monday, true},
+		{"0 0 */2 * *", monday.AddDate(0, 0, 1), false},
+		{"0 0 * * */2", monday, false},
+		{"0 0 13 * */2", tuesday13, true},
+		{"0 0 19 * */2", monday, false},
+	}
+	for _, tt := range tests {
+		spec, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expr, err)
+		}
+		if got := spec.dayMatches(tt.day); got != tt.want {
+			t.Errorf("dayMatches(%q, %s) = %v, want %v", tt.expr, tt.day.Format("Mon 2006-01-02"), got, tt.want)
+		}
+	}
+}
+
+func TestScheduleCompile(t *testing.T) {
+	tests := []struct {
+		schedule Schedule
+		err      error
+	}{
+		{Schedule{Cron: "0 9 * * 1-5", Timezone: "UTC"}, nil},
+		{Schedule{Interval: "90m"}, nil},
+		{Schedule{}, ErrInvalidSchedule},
+		{Schedule{Cron: "* * * * *", Interval: "1h"}, ErrInvalidSchedule},
+		{Schedule{Interval: "0s"}, ErrInvalidSchedule},
+		{Schedule{Interval: "soon"}, ErrInvalidSchedule},
+		{Schedule{Cron: "* * * * *", Timezone: "Mars/Olympus"}, ErrInvalidSchedule},
+		{Schedule{Cron: "0 0 31 2 *"}, ErrInvalidCron},
+		{Schedule{Cron: "0 0 30 2 *", Timezone: "UTC"}, ErrInvalidCron},
+	}
+	for _, tt := range tests {
+		s := tt.schedule
+		err := s.compile()
+		if !errors.Is(err, tt.err) || (tt.err == nil) != (err == nil) {
+			t.Errorf("compile(cron %q, interval %q, tz %q) = %v, want %v", s.Cron, s.Interval, s.Timezone, err, tt.err)
+		}
+	}
+}
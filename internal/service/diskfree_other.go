@@ -0,0 +1,7 @@
+//go:build !(linux || darwin || freebsd || dragonfly)
+
+package service
+
+func diskFree(path string) (int64, bool) {
+	return 0, false
+}
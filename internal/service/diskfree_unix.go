@@ -0,0 +1,13 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package service
+
+import "syscall"
+
+func diskFree(path string) (int64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, false
+	}
+	return int64(st.Bavail) * int64(st.Bsize), true
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,13 +13,58 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// StatusError is returned when the origin answers with anything but 200.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %d", e.Code)
+}
+
+// RetryPolicy fetches a file again after network errors and 5xx, 408 or 429
+// answers, doubling Backoff after every attempt.
+type RetryPolicy struct {
+	Retries int
+	Backoff time.Duration
+}
+
+// do runs fetch until it succeeds, fails for good or runs out of retries.
+func (p RetryPolicy) do(ctx context.Context, fetch func() (retry bool, err error)) error {
+	backoff := p.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := fetch()
+		if err == nil || !retry || attempt >= p.Retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.Code
+		return code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+	}
+	return true
+}
+
 type DownloadResult struct {
 	Index    int
 	FilePath string
 	Error    error
 }
 
-func DownloadFiles(ctx context.Context, urls []string, tempDir string, timeout time.Duration) ([]string, []error) {
+func DownloadFiles(ctx context.Context, urls []string, tempDir string, timeout time.Duration, retry RetryPolicy, progress Progress) ([]string, []error) {
 	results := make(chan DownloadResult, len(urls))
 	g, ctx := errgroup.WithContext(ctx)
 	ctx, cancel := context.WithTimeout(ctx, timeout)
@@ -27,7 +73,12 @@ func DownloadFiles(ctx context.Context, urls []string, tempDir string, timeout t
 	for i, url := range urls {
 		i, url := i, url
 		g.Go(func() error {
-			filePath, err := downloadSingleFile(ctx, url, tempDir)
+			var filePath string
+			err := retry.do(ctx, func() (bool, error) {
+				var err error
+				filePath, err = downloadSingleFile(ctx, i, url, tempDir, progress)
+				return retryable(err), err
+			})
 			results <- DownloadResult{
 				Index:    i,
 				FilePath: filePath,
@@ -56,22 +107,56 @@ func DownloadFiles(ctx context.Context, urls []string, tempDir string, timeout t
 	return downloaded, errors
 }
 
-func downloadSingleFile(ctx context.Context, url, dir string) (string, error) {
+func openSource(ctx context.Context, url string) (io.ReadCloser, int64, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return "", err
+		return nil, 0, err
 	}
 
 	client := http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return nil, 0, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("status %d", resp.StatusCode)
+		resp.Body.Close()
+		return nil, 0, &StatusError{Code: resp.StatusCode}
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// probeSize asks the origin for the file size with a HEAD request and
+// returns -1 when it is unknown.
+func probeSize(ctx context.Context, url string) int64 {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return -1
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return -1
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return -1
+	}
+	return resp.ContentLength
+}
+
+func downloadSingleFile(ctx context.Context, index int, url, dir string, progress Progress) (path string, err error) {
+	if progress != nil {
+		progress.Begin(index)
+		defer func() { progress.End(index, err) }()
+	}
+	body, total, err := openSource(ctx, url)
+	if err != nil {
+		return "", err
 	}
+	defer body.Close()
 
 	ext := filepath.Ext(url)
 	if ext == "" {
@@ -84,10 +169,12 @@ func downloadSingleFile(ctx context.Context, url, dir string) (string, error) {
 	}
 	defer file.Close()
 
-	if _, err := io.Copy(file, resp.Body); err != nil {
+	pw := newProgressWriter(file, index, total, progress)
+	if _, err := io.Copy(pw, body); err != nil {
 		os.Remove(file.Name())
 		return "", err
 	}
+	pw.done()
 
 	return file.Name(), nil
 }
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"sync"
+	"test_ex_zip/internal"
+	"time"
+)
+
+const (
+	EventStatus   = "status"
+	EventFile     = "file"
+	EventProgress = "progress"
+
+	eventHistory    = 1024
+	subscriberQueue = 64
+)
+
+type Event struct {
+	ID         uint64              `json:"-"`
+	Type       string              `json:"type"`
+	TaskID     string              `json:"task_id"`
+	Status     internal.TaskStatus `json:"status,omitempty"`
+	File       *int                `json:"file,omitempty"`
+	FileStatus string              `json:"file_status,omitempty"`
+	Bytes      int64               `json:"bytes,omitempty"`
+	Total      int64               `json:"total,omitempty"`
+	Error      string              `json:"error,omitempty"`
+	Time       time.Time           `json:"time"`
+}
+
+// Terminal reports whether the event moves its task into a final state.
+func (e Event) Terminal() bool {
+	return e.Type == EventStatus && (e.Status == internal.StatusCompleted ||
+		e.Status == internal.StatusPartial || e.Status == internal.StatusFailed)
+}
+
+// EventBus fans task events out to subscribers and keeps the most recent
+// ones so a client can resume from the last event ID it saw.
+type EventBus struct {
+	mu      sync.Mutex
+	lastID  uint64
+	history []Event
+	subs    map[*Subscription]struct{}
+	closed  bool
+}
+
+// Subscription delivers events for one task, or for all tasks when TaskID is
+// empty. C is closed when the subscriber falls behind or is cancelled.
+type Subscription struct {
+	C      <-chan Event
+	TaskID string
+
+	ch  chan Event
+	bus *EventBus
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[*Subscription]struct{})}
+}
+
+func (b *EventBus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	e.ID = b.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if len(b.history) == eventHistory {
+		copy(b.history, b.history[1:])
+		b.history = b.history[:eventHistory-1]
+	}
+	b.history = append(b.history, e)
+
+	for sub := range b.subs {
+		if sub.TaskID != "" && sub.TaskID != e.TaskID {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			b.drop(sub)
+		}
+	}
+}
+
+// Subscribe registers a subscriber and returns the retained events after
+// lastID together with the ID of the latest published event. complete is
+// false when events after lastID have already been discarded.
+func (b *EventBus) Subscribe(taskID string, lastID uint64) (sub *Subscription, backlog []Event, latest uint64, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	complete = lastID >= b.lastID || len(b.history) > 0 && b.history[0].ID <= lastID+1
+	for _, e := range b.history {
+		if e.ID > lastID && (taskID == "" || e.TaskID == taskID) {
+			backlog = append(backlog, e)
+		}
+	}
+
+	ch := make(chan Event, subscriberQueue)
+	sub = &Subscription{C: ch, TaskID: taskID, ch: ch, bus: b}
+	b.subs[sub] = struct{}{}
+	if b.closed {
+		b.drop(sub)
+	}
+	return sub, backlog, b.lastID, complete
+}
+
+// Close disconnects every subscriber. Later subscriptions start closed.
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		b.drop(sub)
+	}
+}
+
+func (b *EventBus) Closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+func (s *Subscription) Cancel() {
+	s.bus.mu.Lock()
+	s.bus.drop(s)
+	s.bus.mu.Unlock()
+}
+
+// drop must be called with mu held.
+func (b *EventBus) drop(sub *Subscription) {
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+func (m *TaskManager) Events() *EventBus {
+	return m.events
+}
+
+// setStatus must be called with task.Mu held.
+func (m *TaskManager) setStatus(task *internal.Task, status internal.TaskStatus) {
+	if task.Status == status {
+		return
+	}
+	task.Status = status
+	m.events.Publish(Event{Type: EventStatus, TaskID: task.ID, Status: status, Error: task.Error})
+}
+
+func (m *TaskManager) publishFile(task *internal.Task, i int) {
+	file := task.Files[i]
+	m.events.Publish(Event{Type: EventFile, TaskID: task.ID, File: &i, FileStatus: file.Status, Error: file.Error})
+}
+
+// Snapshot builds a status event describing the task as it is now.
+func (m *TaskManager) Snapshot(task *internal.Task, id uint64) Event {
+	task.Mu.Lock()
+	defer task.Mu.Unlock()
+	return Event{ID: id, Type: EventStatus, TaskID: task.ID, Status: task.Status, Error: task.Error, Time: time.Now()}
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"test_ex_zip/internal"
+)
+
+var (
+	ErrInvalidFileName   = errors.New("invalid file name")
+	ErrDuplicateFileName = errors.New("duplicate file name")
+)
+
+type FileSpec struct {
+	URL  string `json:"url"`
+	Name string `json:"name,omitempty"`
+	Size int64  `json:"size,omitempty"`
+}
+
+type FileError struct {
+	Index int
+	Err   error
+}
+
+// BatchError lists every rejected item of a multi-file request; none of the
+// files were added.
+type BatchError struct {
+	Errors []FileError
+}
+
+func (e *BatchError) Error() string {
+	parts := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		parts[i] = fmt.Sprintf("file %d: %v", fe.Index, fe.Err)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, fe := range e.Errors {
+		errs[i] = fe.Err
+	}
+	return errs
+}
+
+func (m *TaskManager) AddFile(taskID string, spec FileSpec) error {
+	err := m.AddFiles(taskID, []FileSpec{spec}, false)
+	var batch *BatchError
+	if errors.As(err, &batch) {
+		return batch.Errors[0].Err
+	}
+	return err
+}
+
+// AddFiles validates all specs first and adds them only if every one is
+// valid. Processing starts once the task is full or when start is set.
+func (m *TaskManager) AddFiles(taskID string, specs []FileSpec, start bool) error {
+	task, err := m.GetTask(taskID)
+	if err != nil {
+		return err
+	}
+
+	task.Mu.Lock()
+	defer task.Mu.Unlock()
+
+	if task.Status != internal.StatusPending {
+		return ErrTaskStarted
+	}
+	if err := validateFiles(&task.Settings, task.Files, specs); err != nil {
+		return err
+	}
+
+	task.Files = append(task.Files, newFiles(specs)...)
+	m.maybeStart(task, start)
+	m.index.put(task.ID, taskTokens(task))
+	return nil
+}
+
+// validateFiles also rejects files whose name in the archive is already
+// taken, by the task or earlier in the batch, since a zip would end up with
+// duplicate entries.
+func validateFiles(settings *internal.TaskSettings, existing []internal.File, specs []FileSpec) error {
+	names := make(map[string]bool, len(existing)+len(specs))
+	for i := range existing {
+		names[existing[i].ArchiveName()] = true
+	}
+
+	var batch BatchError
+	for i, spec := range specs {
+		if len(existing)+i >= settings.MaxFiles {
+			batch.Errors = append(batch.Errors, FileError{Index: i, Err: ErrMaxFiles})
+			continue
+		}
+		if err := validateFile(settings.AllowedExts, spec); err != nil {
+			batch.Errors = append(batch.Errors, FileError{Index: i, Err: err})
+			continue
+		}
+		file := internal.File{URL: spec.URL, Name: spec.Name}
+		if name := file.ArchiveName(); names[name] {
+			batch.Errors = append(batch.Errors, FileError{Index: i, Err: ErrDuplicateFileName})
+		} else {
+			names[name] = true
+		}
+	}
+	if len(batch.Errors) > 0 {
+		return &batch
+	}
+	return nil
+}
+
+func validateFile(allowedExts []string, spec FileSpec) error {
+	if !slices.Contains(allowedExts, filepath.Ext(spec.URL)) {
+		return ErrInvalidFileType
+	}
+	if spec.Name != "" {
+		if spec.Name != filepath.Base(spec.Name) || spec.Name == "." || spec.Name == ".." || strings.ContainsRune(spec.Name, '\\') {
+			return ErrInvalidFileName
+		}
+		if !slices.Contains(allowedExts, filepath.Ext(spec.Name)) {
+			return ErrInvalidFileType
+		}
+	}
+	return nil
+}
+
+func newFiles(specs []FileSpec) []internal.File {
+	files := make([]internal.File, len(specs))
+	for i, spec := range specs {
+		files[i] = internal.File{
+			URL:    spec.URL,
+			Name:   spec.Name,
+			Status: "queued",
+			Size:   spec.Size,
+		}
+	}
+	return files
+}
+
+// maybeStart must be called with task.Mu held.
+func (m *TaskManager) maybeStart(task *internal.Task, force bool) {
+	if task.Stream || len(task.Files) == 0 {
+		return
+	}
+	if len(task.Files) == task.Settings.MaxFiles || force {
+		m.setStatus(task, internal.StatusQueued)
+		// While draining the task stays queued and is saved for the next start.
+		if m.track() {
+			go m.processTask(task)
+		}
+	}
+}
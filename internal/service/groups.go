@@ -0,0 +1,499 @@
+package service
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"test_ex_zip/internal"
+	"time"
+)
+
+const groupsFile = "groups.json"
+
+const (
+	MergeNone      = ""
+	MergeWaiting   = "waiting"
+	MergeRunning   = "merging"
+	MergeCompleted = "completed"
+	MergeFailed    = "failed"
+)
+
+var (
+	ErrGroupNotFound = errors.New("group not found")
+	ErrInvalidGroup  = errors.New("invalid group")
+)
+
+// Group bundles tasks, e.g. a job split across several tasks because of
+// MaxFiles. Its merged archive holds each member's files under a folder
+// named after the task ID.
+type Group struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name,omitempty"`
+	Owner       string    `json:"owner,omitempty"`
+	TaskIDs     []string  `json:"task_ids"`
+	CreatedAt   time.Time `json:"created_at"`
+	MergeStatus string    `json:"merge_status,omitempty"`
+	MergeError  string    `json:"merge_error,omitempty"`
+	MergedAt    time.Time `json:"merged_at,omitzero"`
+	ArchiveKey  string    `json:"archive_key,omitempty"`
+	ArchiveHash string    `json:"archive_hash,omitempty"`
+	ArchiveSize int64     `json:"archive_size,omitempty"`
+}
+
+type GroupMember struct {
+	TaskID string              `json:"task_id"`
+	Status internal.TaskStatus `json:"status,omitempty"`
+	Found  bool                `json:"found"`
+}
+
+// GroupStatus aggregates the member tasks: processing while any member is
+// unfinished, then completed, failed or partial for a mix. Members that were
+// already cleaned up count as failed.
+type GroupStatus struct {
+	Status  internal.TaskStatus         `json:"status"`
+	Counts  map[internal.TaskStatus]int `json:"counts"`
+	Members []GroupMember               `json:"members"`
+}
+
+type groupStore struct {
+	mu     sync.Mutex
+	groups map[string]*Group
+}
+
+// CreateGroup makes a group owned by t. Its members must belong to t as
+// well.
+func (m *TaskManager) CreateGroup(t Tenant, name string, taskIDs []string, merge bool) (Group, error) {
+	if len(taskIDs) == 0 {
+		return Group{}, fmt.Errorf("%w: no tasks", ErrInvalidGroup)
+	}
+	var members []string
+	for _, id := range taskIDs {
+		if _, err := m.GetTaskFor(t, id); err != nil {
+			return Group{}, fmt.Errorf("%w: task %s", ErrTaskNotFound, id)
+		}
+		if !slices.Contains(members, id) {
+			members = append(members, id)
+		}
+	}
+
+	g := &Group{
+		ID:        m.ids.NewID(),
+		Name:      name,
+		Owner:     string(t),
+		TaskIDs:   members,
+		CreatedAt: time.Now(),
+	}
+	m.groups.mu.Lock()
+	m.groups.groups[g.ID] = g
+	m.groups.mu.Unlock()
+
+	if merge {
+		return m.MergeGroup(g.ID)
+	}
+	return *g, nil
+}
+
+func (m *TaskManager) GetGroup(id string) (Group, GroupStatus, error) {
+	m.groups.mu.Lock()
+	g, ok := m.groups.groups[id]
+	var group Group
+	if ok {
+		group = *g
+		group.TaskIDs = slices.Clone(g.TaskIDs)
+	}
+	m.groups.mu.Unlock()
+	if !ok {
+		return Group{}, GroupStatus{}, ErrGroupNotFound
+	}
+	return group, m.groupStatus(group.TaskIDs), nil
+}
+
+func (m *TaskManager) groupStatus(taskIDs []string) GroupStatus {
+	st := GroupStatus{Counts: make(map[internal.TaskStatus]int)}
+	finished := true
+	for _, id := range taskIDs {
+		member := GroupMember{TaskID: id}
+		if task, err := m.GetTask(id); err == nil {
+			task.Mu.Lock()
+			member.Status = task.Status
+			member.Found = true
+			finished = finished && task.IsFinished()
+			task.Mu.Unlock()
+			st.Counts[member.Status]++
+		} else {
+			st.Counts[internal.StatusFailed]++
+		}
+		st.Members = append(st.Members, member)
+	}
+
+	switch {
+	case !finished:
+		st.Status = internal.StatusProcessing
+	case st.Counts[internal.StatusCompleted] == len(taskIDs):
+		st.Status = internal.StatusCompleted
+	case st.Counts[internal.StatusFailed] == len(taskIDs):
+		st.Status = internal.StatusFailed
+	default:
+		st.Status = internal.StatusPartial
+	}
+	return st
+}
+
+// MergeGroup requests the merged archive. It is built right away when every
+// member has finished, otherwise as soon as the last one does.
+func (m *TaskManager) MergeGroup(id string) (Group, error) {
+	m.groups.mu.Lock()
+	g, ok := m.groups.groups[id]
+	if !ok {
+		m.groups.mu.Unlock()
+		return Group{}, ErrGroupNotFound
+	}
+	if g.MergeStatus == MergeNone || g.MergeStatus == MergeFailed {
+		g.MergeStatus = MergeWaiting
+		g.MergeError = ""
+	}
+	m.groups.mu.Unlock()
+
+	m.startMerge(id)
+	return m.groupCopy(id), nil
+}
+
+func (m *TaskManager) groupCopy(id string) Group {
+	m.groups.mu.Lock()
+	defer m.groups.mu.Unlock()
+	if g, ok := m.groups.groups[id]; ok {
+		c := *g
+		c.TaskIDs = slices.Clone(g.TaskIDs)
+		return c
+	}
+	return Group{}
+}
+
+// taskFinishedForGroups starts the merges that were waiting for this task.
+func (m *TaskManager) taskFinishedForGroups(taskID string) {
+	m.groups.mu.Lock()
+	var waiting []string
+	for id, g := range m.groups.groups {
+		if g.MergeStatus == MergeWaiting && slices.Contains(g.TaskIDs, taskID) {
+			waiting = append(waiting, id)
+		}
+	}
+	m.groups.mu.Unlock()
+
+	for _, id := range waiting {
+		m.startMerge(id)
+	}
+}
+
+func (m *TaskManager) startMerge(id string) {
+	m.groups.mu.Lock()
+	g, ok := m.groups.groups[id]
+	if !ok || g.MergeStatus != MergeWaiting {
+		m.groups.mu.Unlock()
+		return
+	}
+	taskIDs := slices.Clone(g.TaskIDs)
+	m.groups.mu.Unlock()
+
+	if m.groupStatus(taskIDs).Status == internal.StatusProcessing {
+		return
+	}
+
+	m.groups.mu.Lock()
+	if g.MergeStatus != MergeWaiting || !m.track() {
+		m.groups.mu.Unlock()
+		return
+	}
+	g.MergeStatus = MergeRunning
+	owner := g.Owner
+	m.groups.mu.Unlock()
+
+	go m.mergeGroup(id, owner, taskIDs)
+}
+
+func (m *TaskManager) mergeGroup(id, owner string, taskIDs []string) {
+	defer m.running.Done()
+	cfg := m.config()
+	prt, err := cfg.MakeTimePr()
+	if err != nil {
+		prt = 5 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(m.runCtx, prt)
+	defer cancel()
+
+	// The janitor leaves the group-<id> scratch folder alone while the merge
+	// runs, see unfinished.
+	name := "group-" + id
+	key := archiveKey(owner, name+".zip")
+	scratchDir := filepath.Join(cfg.TempDir, name)
+	scratchPath := filepath.Join(scratchDir, name+".zip")
+	var hash string
+	var size int64
+	err = os.MkdirAll(scratchDir, 0755)
+	defer os.RemoveAll(scratchDir)
+	if err == nil {
+		hash, size, err = m.buildMergedArchive(ctx, taskIDs, scratchPath)
+	}
+	if err == nil {
+		err = m.store.PutFile(ctx, key, scratchPath)
+	}
+
+	m.groups.mu.Lock()
+	defer m.groups.mu.Unlock()
+	g, ok := m.groups.groups[id]
+	if err != nil || !ok {
+		if ok && m.runCtx.Err() != nil {
+			// Cancelled by Shutdown: merge again after the next start.
+			g.MergeStatus = MergeWaiting
+		} else if ok {
+			g.MergeStatus = MergeFailed
+			g.MergeError = err.Error()
+			log.Printf("Failed to merge group %s: %v", id, err)
+		} else {
+			m.store.Delete(ctx, key)
+		}
+		return
+	}
+	g.MergeStatus = MergeCompleted
+	g.MergedAt = time.Now()
+	g.ArchiveKey = key
+	g.ArchiveHash = hash
+	g.ArchiveSize = size
+}
+
+// buildMergedArchive copies the entries of every member archive into one zip
+// without decompressing them. Members without an archive are skipped.
+func (m *TaskManager) buildMergedArchive(ctx context.Context, taskIDs []string, path string) (string, int64, error) {
+	type source struct {
+		taskID, key string
+		size        int64
+	}
+	var sources []source
+	var need int64
+	for _, id := range taskIDs {
+		task, err := m.GetTask(id)
+		if err != nil {
+			continue
+		}
+		task.Mu.Lock()
+		if task.HasArchive() {
+			sources = append(sources, source{taskID: id, key: task.ArchiveKey, size: task.ArchiveSize})
+			need += task.ArchiveSize
+		}
+		task.Mu.Unlock()
+	}
+	if len(sources) == 0 {
+		return "", 0, errors.New("no member has an archive")
+	}
+
+	if err := m.reserve(ctx, need); err != nil {
+		return "", 0, err
+	}
+	defer m.release(need)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", 0, err
+	}
+	zipWriter := zip.NewWriter(file)
+	for _, src := range sources {
+		if err := copyArchiveEntries(ctx, m, zipWriter, src.key, src.taskID); err != nil {
+			file.Close()
+			return "", 0, fmt.Errorf("task %s: %w", src.taskID, err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		file.Close()
+		return "", 0, err
+	}
+	if err := file.Close(); err != nil {
+		return "", 0, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	hash, err := HashFile(path)
+	return hash, info.Size(), err
+}
+
+func copyArchiveEntries(ctx context.Context, m *TaskManager, zipWriter *zip.Writer, key, folder string) error {
+	obj, err := m.store.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	reader, err := zip.NewReader(readerAt(obj.ReadSeekCloser), obj.Size)
+	if err != nil {
+		return err
+	}
+	for _, entry := range reader.File {
+		header := entry.FileHeader
+		header.Name = folder + "/" + entry.Name
+		raw, err := entry.OpenRaw()
+		if err != nil {
+			return err
+		}
+		w, err := zipWriter.CreateRaw(&header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readerAt lets zip.NewReader read stores that only offer Seek and Read.
+func readerAt(r io.ReadSeeker) io.ReaderAt {
+	if ra, ok := r.(io.ReaderAt); ok {
+		return ra
+	}
+	return &seekReaderAt{r: r}
+}
+
+type seekReaderAt struct {
+	mu sync.Mutex
+	r  io.ReadSeeker
+}
+
+func (s *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(s.r, p)
+}
+
+func (m *TaskManager) DeleteGroup(ctx context.Context, id string) error {
+	m.groups.mu.Lock()
+	g, ok := m.groups.groups[id]
+	if ok {
+		delete(m.groups.groups, id)
+	}
+	m.groups.mu.Unlock()
+	if !ok {
+		return ErrGroupNotFound
+	}
+	if g.ArchiveKey != "" {
+		return m.store.Delete(ctx, g.ArchiveKey)
+	}
+	return nil
+}
+
+func (m *TaskManager) GroupDownloadLink(id string, opts LinkOptions) (*DownloadLink, error) {
+	if opts.TTL <= 0 {
+		ttl, err := m.config().MakeTimeLink()
+		if err != nil {
+			return nil, err
+		}
+		opts.TTL = ttl
+	}
+	return m.links.SignPath(groupScope(id), "/groups/"+id+"/download", opts), nil
+}
+
+func (m *TaskManager) VerifyGroupLink(id string, q url.Values, clientIP string, consume bool) error {
+	if !IsSignedLink(q) {
+		if m.config().AllowUnsigned {
+			return nil
+		}
+		return ErrLinkInvalid
+	}
+	return m.links.Verify(groupScope(id), q, clientIP, consume)
+}
+
+func groupScope(id string) string {
+	return "group:" + id
+}
+
+// sweepGroups drops merged archives older than retention.after_completion
+// together with their group.
+func (m *TaskManager) sweepGroups(ctx context.Context, now time.Time) {
+	afterCompletion, _ := m.config().Retention.MakeTimeCompletion()
+	if afterCompletion <= 0 {
+		return
+	}
+
+	m.groups.mu.Lock()
+	var expired []string
+	for id, g := range m.groups.groups {
+		if g.MergeStatus == MergeCompleted && now.After(g.MergedAt.Add(afterCompletion)) {
+			expired = append(expired, id)
+		}
+	}
+	m.groups.mu.Unlock()
+
+	for _, id := range expired {
+		if err := m.DeleteGroup(ctx, id); err != nil {
+			log.Printf("Failed to delete archive of group %s: %v", id, err)
+		}
+	}
+}
+
+func (m *TaskManager) groupArchiveBytes() int64 {
+	m.groups.mu.Lock()
+	defer m.groups.mu.Unlock()
+
+	var total int64
+	for _, g := range m.groups.groups {
+		total += g.ArchiveSize
+	}
+	return total
+}
+
+func (m *TaskManager) saveGroups() error {
+	m.groups.mu.Lock()
+	list := make([]Group, 0, len(m.groups.groups))
+	for _, g := range m.groups.groups {
+		list = append(list, *g)
+	}
+	m.groups.mu.Unlock()
+	return m.saveState(groupsFile, list)
+}
+
+// restoreGroups loads the groups saved by the last Shutdown, after the tasks,
+// and starts the merges that were waiting. Like the task file, the group
+// file is removed once read.
+func (m *TaskManager) restoreGroups() error {
+	var list []*Group
+	if err := m.loadState(groupsFile, &list); err != nil {
+		return err
+	}
+
+	var waiting []string
+	m.groups.mu.Lock()
+	for _, g := range list {
+		if g.MergeStatus == MergeRunning {
+			g.MergeStatus = MergeWaiting
+		}
+		if g.MergeStatus == MergeWaiting {
+			waiting = append(waiting, g.ID)
+		}
+		m.groups.groups[g.ID] = g
+	}
+	m.groups.mu.Unlock()
+	for _, id := range waiting {
+		m.startMerge(id)
+	}
+
+	if len(list) > 0 {
+		log.Printf("Restored %d groups", len(list))
+	}
+	err := os.Remove(filepath.Join(m.config().StateDir, groupsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
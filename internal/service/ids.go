@@ -0,0 +1,81 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+const (
+	IDFormatRandom = "random"
+	IDFormatUUIDv7 = "uuidv7"
+)
+
+// IDGenerator produces task identifiers and recognises well-formed ones, so
+// malformed IDs can be rejected before any lookup.
+type IDGenerator interface {
+	NewID() string
+	Valid(id string) bool
+}
+
+func NewIDGenerator(format string) (IDGenerator, error) {
+	switch format {
+	case "", IDFormatRandom:
+		return RandomIDs{}, nil
+	case IDFormatUUIDv7:
+		return UUIDv7IDs{}, nil
+	}
+	return nil, fmt.Errorf("unknown task id format %q", format)
+}
+
+// RandomIDs are 128 random bits in lowercase hex. They carry no creation
+// time and cannot be enumerated.
+type RandomIDs struct{}
+
+func (RandomIDs) NewID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func (RandomIDs) Valid(id string) bool {
+	return len(id) == 32 && isLowerHex(id)
+}
+
+// UUIDv7IDs sort by creation time and keep 74 random bits, at the cost of
+// revealing the creation millisecond.
+type UUIDv7IDs struct{}
+
+func (UUIDv7IDs) NewID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixMilli())<<16|binary.BigEndian.Uint64(b[:8])&0xffff)
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+
+	h := hex.EncodeToString(b[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func (UUIDv7IDs) Valid(id string) bool {
+	if len(id) != 36 || id[8] != '-' || id[13] != '-' || id[18] != '-' || id[23] != '-' {
+		return false
+	}
+	h := id[:8] + id[9:13] + id[14:18] + id[19:23] + id[24:]
+	if !isLowerHex(h) {
+		return false
+	}
+	return h[12] == '7' && (h[16] == '8' || h[16] == '9' || h[16] == 'a' || h[16] == 'b')
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
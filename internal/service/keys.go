@@ -0,0 +1,338 @@
+package service
+
+import (
+	"cmp"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"test_ex_zip/internal"
+	"time"
+)
+
+const keysFile = "api_keys.json"
+
+const (
+	QuotaConcurrentTasks = "concurrent_tasks"
+	QuotaTasksPerDay     = "tasks_per_day"
+	QuotaBytesPerDay     = "bytes_per_day"
+)
+
+var (
+	ErrInvalidToken  = errors.New("invalid api key")
+	ErrKeyNotFound   = errors.New("api key not found")
+	ErrKeyExists     = errors.New("api key already exists")
+	ErrInvalidKey    = errors.New("invalid api key settings")
+	ErrKeyInConfig   = errors.New("api key is defined in the config")
+	ErrProfileDenied = errors.New("profile not allowed for this api key")
+)
+
+// QuotaError reports a key limit that a new task would exceed. ResetAt is
+// zero for the concurrent task limit, which frees up as tasks finish.
+type QuotaError struct {
+	Quota   string
+	Limit   int64
+	ResetAt time.Time
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota exceeded: %s (limit %d)", e.Quota, e.Limit)
+}
+
+// APIKey is a caller identity. Only the SHA-256 of the secret is kept.
+type APIKey struct {
+	ID         string             `json:"id"`
+	Admin      bool               `json:"admin,omitempty"`
+	Limits     internal.KeyLimits `json:"limits"`
+	FromConfig bool               `json:"from_config,omitempty"`
+	CreatedAt  time.Time          `json:"created_at,omitzero"`
+
+	hash string
+}
+
+// KeyUsage counts the tasks and downloaded bytes of a key on Day (UTC).
+type KeyUsage struct {
+	Day         string `json:"day"`
+	Tasks       int    `json:"tasks"`
+	Bytes       int64  `json:"bytes"`
+	ActiveTasks int    `json:"active_tasks"`
+}
+
+type keyStore struct {
+	mu     sync.Mutex
+	byHash map[string]*APIKey
+	byID   map[string]*APIKey
+	usage  map[string]*KeyUsage
+}
+
+// storedKey is how created keys and the daily usage are saved.
+type storedKey struct {
+	APIKey
+	Hash string `json:"hash"`
+}
+
+type keysState struct {
+	Keys  []storedKey          `json:"keys"`
+	Usage map[string]*KeyUsage `json:"usage"`
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func usageDay(now time.Time) string {
+	return now.UTC().Format(time.DateOnly)
+}
+
+func nextDay(now time.Time) time.Time {
+	y, mo, d := now.UTC().Date()
+	return time.Date(y, mo, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// loadKeys reads the created keys and usage from state_dir, then adds the
+// config keys.
+func (m *TaskManager) loadKeys() error {
+	m.keys = keyStore{
+		byHash: make(map[string]*APIKey),
+		byID:   make(map[string]*APIKey),
+		usage:  make(map[string]*KeyUsage),
+	}
+	var state keysState
+	if err := m.loadState(keysFile, &state); err != nil {
+		return err
+	}
+	for _, stored := range state.Keys {
+		key := stored.APIKey
+		key.hash = stored.Hash
+		m.keys.byHash[key.hash] = &key
+		m.keys.byID[key.ID] = &key
+	}
+	for id, usage := range state.Usage {
+		usage.ActiveTasks = 0
+		m.keys.usage[id] = usage
+	}
+	m.keys.setConfigKeys(m.config().Auth.Keys)
+	return nil
+}
+
+func (m *TaskManager) saveKeys() error {
+	m.keys.mu.Lock()
+	state := keysState{Usage: make(map[string]*KeyUsage, len(m.keys.usage))}
+	for _, key := range m.keys.byID {
+		if !key.FromConfig {
+			state.Keys = append(state.Keys, storedKey{APIKey: *key, Hash: key.hash})
+		}
+	}
+	for id, usage := range m.keys.usage {
+		u := *usage
+		state.Usage[id] = &u
+	}
+	m.keys.mu.Unlock()
+
+	slices.SortFunc(state.Keys, func(a, b storedKey) int { return cmp.Compare(a.ID, b.ID) })
+	return m.saveState(keysFile, state)
+}
+
+// setConfigKeys replaces the keys that come from the config. A created key
+// with the same ID wins.
+func (s *keyStore) setConfigKeys(keys []internal.APIKeyConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, key := range s.byHash {
+		if key.FromConfig {
+			delete(s.byHash, hash)
+			delete(s.byID, key.ID)
+		}
+	}
+	for _, c := range keys {
+		if _, exists := s.byID[c.ID]; exists {
+			log.Printf("Ignoring config api key %q: a created key has the same id", c.ID)
+			continue
+		}
+		key := &APIKey{ID: c.ID, Admin: c.Admin, Limits: c.Limits, FromConfig: true, hash: hashToken(c.Key)}
+		s.byHash[key.hash] = key
+		s.byID[key.ID] = key
+	}
+}
+
+func (s *keyStore) usageFor(id string, now time.Time) *KeyUsage {
+	u, ok := s.usage[id]
+	if !ok {
+		u = &KeyUsage{}
+		s.usage[id] = u
+	}
+	if day := usageDay(now); u.Day != day {
+		u.Day, u.Tasks, u.Bytes = day, 0, 0
+	}
+	return u
+}
+
+// admit counts a new task of owner and, when enforce is set, checks it
+// against the limits of the owner's key. Owners without a key are not
+// tracked.
+func (s *keyStore) admit(owner, profile string, enforce bool, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.byID[owner]
+	if !ok {
+		return nil
+	}
+	u := s.usageFor(owner, now)
+	if enforce {
+		l := key.Limits
+		if len(l.Profiles) > 0 && !slices.Contains(l.Profiles, profile) {
+			return ErrProfileDenied
+		}
+		if l.TasksPerDay > 0 && u.Tasks >= l.TasksPerDay {
+			return &QuotaError{Quota: QuotaTasksPerDay, Limit: int64(l.TasksPerDay), ResetAt: nextDay(now)}
+		}
+		if l.BytesPerDay > 0 && u.Bytes >= l.BytesPerDay {
+			return &QuotaError{Quota: QuotaBytesPerDay, Limit: l.BytesPerDay, ResetAt: nextDay(now)}
+		}
+		if l.ConcurrentTasks > 0 && u.ActiveTasks >= l.ConcurrentTasks {
+			return &QuotaError{Quota: QuotaConcurrentTasks, Limit: int64(l.ConcurrentTasks)}
+		}
+	}
+	u.Tasks++
+	u.ActiveTasks++
+	return nil
+}
+
+// cancel undoes admit for a task that was not created after all.
+func (s *keyStore) cancel(owner string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byID[owner]; ok {
+		u := s.usageFor(owner, now)
+		u.Tasks = max(u.Tasks-1, 0)
+		u.ActiveTasks = max(u.ActiveTasks-1, 0)
+	}
+}
+
+// finished releases the concurrent slot of a task and adds the bytes it
+// downloaded to today's usage.
+func (s *keyStore) finished(owner string, bytes int64, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byID[owner]; ok {
+		u := s.usageFor(owner, now)
+		u.Bytes += bytes
+		u.ActiveTasks = max(u.ActiveTasks-1, 0)
+	}
+}
+
+// restored counts an unfinished task loaded from the last shutdown.
+func (s *keyStore) restored(owner string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.usage[owner]; ok {
+		u.ActiveTasks++
+	} else if _, ok := s.byID[owner]; ok {
+		s.usage[owner] = &KeyUsage{ActiveTasks: 1}
+	}
+}
+
+// allowsProfile reports whether the key of owner may use profile.
+func (s *keyStore) allowsProfile(owner, profile string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.byID[owner]
+	return !ok || len(key.Limits.Profiles) == 0 || slices.Contains(key.Limits.Profiles, profile)
+}
+
+func (m *TaskManager) AuthEnabled() bool {
+	return m.config().Auth.Enabled
+}
+
+// Authenticate looks up the key a bearer token belongs to.
+func (m *TaskManager) Authenticate(token string) (APIKey, error) {
+	m.keys.mu.Lock()
+	defer m.keys.mu.Unlock()
+	key, ok := m.keys.byHash[hashToken(token)]
+	if !ok {
+		return APIKey{}, ErrInvalidToken
+	}
+	return *key, nil
+}
+
+// CreateKey adds a key and returns its secret, which is shown only once.
+func (m *TaskManager) CreateKey(id string, admin bool, limits internal.KeyLimits) (APIKey, string, error) {
+	if !internal.ValidKeyID(id) {
+		return APIKey{}, "", fmt.Errorf("%w: id must be 1-64 letters, digits, '.', '-' or '_'", ErrInvalidKey)
+	}
+	if err := m.config().ValidateKeyLimits(limits); err != nil {
+		return APIKey{}, "", fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return APIKey{}, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	key := &APIKey{ID: id, Admin: admin, Limits: limits, CreatedAt: time.Now(), hash: hashToken(token)}
+
+	m.keys.mu.Lock()
+	if _, exists := m.keys.byID[id]; exists {
+		m.keys.mu.Unlock()
+		return APIKey{}, "", ErrKeyExists
+	}
+	m.keys.byHash[key.hash] = key
+	m.keys.byID[id] = key
+	m.keys.mu.Unlock()
+
+	if err := m.saveKeys(); err != nil {
+		log.Printf("Failed to save api keys: %v", err)
+	}
+	return *key, token, nil
+}
+
+// KeyInfo is a key with its usage for today.
+type KeyInfo struct {
+	APIKey
+	Usage KeyUsage `json:"usage"`
+}
+
+func (m *TaskManager) ListKeys() []KeyInfo {
+	now := time.Now()
+	m.keys.mu.Lock()
+	defer m.keys.mu.Unlock()
+
+	infos := make([]KeyInfo, 0, len(m.keys.byID))
+	for _, id := range slices.Sorted(maps.Keys(m.keys.byID)) {
+		infos = append(infos, KeyInfo{APIKey: *m.keys.byID[id], Usage: *m.keys.usageFor(id, now)})
+	}
+	return infos
+}
+
+// DeleteKey revokes a created key. Its tasks keep running; keys from the
+// config are removed by editing the config and reloading.
+func (m *TaskManager) DeleteKey(id string) error {
+	m.keys.mu.Lock()
+	key, ok := m.keys.byID[id]
+	switch {
+	case !ok:
+		m.keys.mu.Unlock()
+		return ErrKeyNotFound
+	case key.FromConfig:
+		m.keys.mu.Unlock()
+		return ErrKeyInConfig
+	}
+	delete(m.keys.byID, id)
+	delete(m.keys.byHash, key.hash)
+	delete(m.keys.usage, id)
+	m.keys.mu.Unlock()
+
+	if err := m.saveKeys(); err != nil {
+		log.Printf("Failed to save api keys: %v", err)
+	}
+	return nil
+}
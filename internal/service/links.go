@@ -0,0 +1,144 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLinkInvalid   = errors.New("invalid download link")
+	ErrLinkExpired   = errors.New("download link expired")
+	ErrLinkExhausted = errors.New("download link exhausted")
+	ErrLinkIP        = errors.New("download link not valid for this client")
+)
+
+type LinkOptions struct {
+	TTL          time.Duration
+	MaxDownloads int
+	ClientIP     string
+}
+
+type DownloadLink struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type LinkSigner struct {
+	key     []byte
+	baseURL string
+
+	uses   map[string]linkUse
+	usesMu sync.Mutex
+}
+
+type linkUse struct {
+	count   int
+	expires int64
+}
+
+func NewLinkSigner(secret, baseURL string) *LinkSigner {
+	key := []byte(secret)
+	if len(key) == 0 {
+		// Links signed with a random key stop working after a restart.
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	return &LinkSigner{
+		key:     key,
+		baseURL: baseURL,
+		uses:    make(map[string]linkUse),
+	}
+}
+
+func (s *LinkSigner) Sign(taskID string, opts LinkOptions) *DownloadLink {
+	return s.SignPath(taskID, "/download/"+taskID, opts)
+}
+
+// SignPath signs a link to path. scope is what Verify is later called with;
+// it keeps a signature for one resource from being valid for another.
+func (s *LinkSigner) SignPath(scope, path string, opts LinkOptions) *DownloadLink {
+	expires := time.Now().Add(opts.TTL).Truncate(time.Second)
+
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	if opts.MaxDownloads > 0 {
+		q.Set("max", strconv.Itoa(opts.MaxDownloads))
+	}
+	if opts.ClientIP != "" {
+		q.Set("ip", opts.ClientIP)
+	}
+	q.Set("sig", s.signature(scope, q))
+
+	return &DownloadLink{
+		URL:       s.baseURL + path + "?" + q.Encode(),
+		ExpiresAt: expires,
+	}
+}
+
+func IsSignedLink(q url.Values) bool {
+	return q.Has("sig")
+}
+
+// Verify checks the link signature and limits. consume counts the request
+// against max downloads; callers consume once a body is served, ranged or not.
+func (s *LinkSigner) Verify(taskID string, q url.Values, clientIP string, consume bool) error {
+	sig := q.Get("sig")
+	if sig == "" || !hmac.Equal([]byte(sig), []byte(s.signature(taskID, q))) {
+		return ErrLinkInvalid
+	}
+
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil {
+		return ErrLinkInvalid
+	}
+	if time.Now().Unix() > expires {
+		return ErrLinkExpired
+	}
+
+	if ip := q.Get("ip"); ip != "" && ip != clientIP {
+		return ErrLinkIP
+	}
+
+	if !q.Has("max") {
+		return nil
+	}
+	max, err := strconv.Atoi(q.Get("max"))
+	if err != nil {
+		return ErrLinkInvalid
+	}
+
+	s.usesMu.Lock()
+	defer s.usesMu.Unlock()
+	use := s.uses[sig]
+	if use.count >= max {
+		return ErrLinkExhausted
+	}
+	if consume {
+		s.uses[sig] = linkUse{count: use.count + 1, expires: expires}
+	}
+	return nil
+}
+
+// Prune forgets download counters of links that have already expired.
+func (s *LinkSigner) Prune(now time.Time) {
+	s.usesMu.Lock()
+	defer s.usesMu.Unlock()
+	for sig, use := range s.uses {
+		if use.expires < now.Unix() {
+			delete(s.uses, sig)
+		}
+	}
+}
+
+func (s *LinkSigner) signature(taskID string, q url.Values) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(taskID + "\n" + q.Get("expires") + "\n" + q.Get("max") + "\n" + q.Get("ip")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"test_ex_zip/internal"
+	"time"
+)
+
+const (
+	SortCreated   = "created_at"
+	SortCompleted = "completed_at"
+
+	DefaultListLimit = 50
+	MaxListLimit     = 500
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type ListFilter struct {
+	Statuses      []internal.TaskStatus
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Labels        []string
+	Owner         string
+	Tenant        Tenant
+	Query         string
+	Sort          string
+	Desc          bool
+	Cursor        string
+	Limit         int
+}
+
+type TaskSummary struct {
+	ID          string              `json:"id"`
+	Status      internal.TaskStatus `json:"status"`
+	Files       int                 `json:"files"`
+	Failed      int                 `json:"failed_files,omitempty"`
+	Labels      []string            `json:"labels,omitempty"`
+	Metadata    map[string]string   `json:"metadata,omitempty"`
+	Owner       string              `json:"owner,omitempty"`
+	HasArchive  bool                `json:"has_archive"`
+	CreatedAt   time.Time           `json:"created_at"`
+	CompletedAt *time.Time          `json:"completed_at,omitempty"`
+}
+
+// ListTasks returns one page of tasks matching the filter and the cursor for
+// the next page, which is empty on the last page. Sorting by completion time
+// only lists finished tasks. A Query keeps tasks found by the search index.
+func (m *TaskManager) ListTasks(f ListFilter) ([]TaskSummary, string, error) {
+	if f.Sort == "" {
+		f.Sort = SortCreated
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultListLimit
+	}
+	f.Limit = min(f.Limit, MaxListLimit)
+
+	var after *listCursor
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil || c.sort != f.Sort || c.desc != f.Desc {
+			return nil, "", ErrInvalidCursor
+		}
+		after = &c
+	}
+
+	var found map[string]struct{}
+	if f.Query != "" {
+		found = m.index.search(f.Query)
+	}
+
+	m.tasksMu.RLock()
+	summaries := make([]TaskSummary, 0, len(m.tasks))
+	for id, task := range m.tasks {
+		if found != nil {
+			if _, ok := found[id]; !ok {
+				continue
+			}
+		}
+		task.Mu.Lock()
+		if f.matches(task) {
+			summaries = append(summaries, summarize(task))
+		}
+		task.Mu.Unlock()
+	}
+	m.tasksMu.RUnlock()
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return f.less(cursorOf(f, summaries[i]), cursorOf(f, summaries[j]))
+	})
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(summaries), func(i int) bool {
+			return f.less(*after, cursorOf(f, summaries[i]))
+		})
+	}
+
+	page := summaries[start:]
+	if len(page) <= f.Limit {
+		return page, "", nil
+	}
+	page = page[:f.Limit]
+	return page, cursorOf(f, page[len(page)-1]).encode(), nil
+}
+
+func (f *ListFilter) matches(task *internal.Task) bool {
+	if len(f.Statuses) > 0 && !slices.Contains(f.Statuses, task.Status) {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && task.CreatedAt.Before(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !task.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+	for _, label := range f.Labels {
+		if !slices.Contains(task.Labels, label) {
+			return false
+		}
+	}
+	if f.Owner != "" && task.Owner != f.Owner || !f.Tenant.Owns(task.Owner) {
+		return false
+	}
+	if f.Sort == SortCompleted && task.CompletedAt.IsZero() {
+		return false
+	}
+	return true
+}
+
+func (f *ListFilter) less(a, b listCursor) bool {
+	if f.Desc {
+		a, b = b, a
+	}
+	if !a.at.Equal(b.at) {
+		return a.at.Before(b.at)
+	}
+	return a.id < b.id
+}
+
+func summarize(task *internal.Task) TaskSummary {
+	s := TaskSummary{
+		ID:         task.ID,
+		Status:     task.Status,
+		Files:      len(task.Files),
+		Labels:     slices.Clone(task.Labels),
+		Metadata:   maps.Clone(task.Metadata),
+		Owner:      task.Owner,
+		HasArchive: task.HasArchive(),
+		CreatedAt:  task.CreatedAt,
+	}
+	for _, file := range task.Files {
+		if file.Status == "failed" {
+			s.Failed++
+		}
+	}
+	if !task.CompletedAt.IsZero() {
+		completed := task.CompletedAt
+		s.CompletedAt = &completed
+	}
+	return s
+}
+
+type listCursor struct {
+	sort string
+	desc bool
+	at   time.Time
+	id   string
+}
+
+func cursorOf(f ListFilter, s TaskSummary) listCursor {
+	c := listCursor{sort: f.Sort, desc: f.Desc, at: s.CreatedAt, id: s.ID}
+	if f.Sort == SortCompleted && s.CompletedAt != nil {
+		c.at = *s.CompletedAt
+	}
+	return c
+}
+
+func (c listCursor) encode() string {
+	raw := strings.Join([]string{c.sort, strconv.FormatBool(c.desc), strconv.FormatInt(c.at.UnixNano(), 10), c.id}, "|")
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (listCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return listCursor{}, err
+	}
+	parts := strings.SplitN(string(raw), "|", 4)
+	if len(parts) != 4 {
+		return listCursor{}, ErrInvalidCursor
+	}
+	desc, err := strconv.ParseBool(parts[1])
+	if err != nil {
+		return listCursor{}, err
+	}
+	nanos, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return listCursor{}, err
+	}
+	return listCursor{sort: parts[0], desc: desc, at: time.Unix(0, nanos), id: parts[3]}, nil
+}
@@ -5,9 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"net/url"
+	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"test_ex_zip/internal"
+	"test_ex_zip/internal/storage"
 	"time"
 )
 
@@ -16,135 +23,440 @@ var (
 	ErrMaxFiles        = errors.New("max files reached")
 	ErrInvalidFileType = errors.New("invalid file type")
 	ErrServerBusy      = errors.New("server busy")
+	ErrTaskStarted     = errors.New("task already started")
+	ErrInvalidLabel    = errors.New("invalid label")
+	ErrIDCollision     = errors.New("could not allocate a unique task id")
+	ErrInvalidCallback = errors.New("invalid callback url")
 )
 
+type TaskOptions struct {
+	Policy    string
+	Stream    bool
+	LegalHold bool
+	Labels    []string
+	Metadata  map[string]string
+	Owner     string
+	CreatedBy string
+	Files     []FileSpec
+	Callback  string
+	Profile   string
+	Overrides internal.ProfileOverrides
+}
+
 type TaskManager struct {
 	tasks      map[string]*internal.Task
 	tasksMu    sync.RWMutex
 	activeJobs chan struct{}
-	cfg        *internal.Config
+	cfg        atomic.Pointer[internal.Config]
+	links      *LinkSigner
+	store      storage.ArchiveStore
+	disk       diskQuota
+	ids        IDGenerator
+	events     *EventBus
+	webhooks   webhookSender
+	index      *searchIndex
+	sched      scheduler
+	groups     groupStore
+	keys       keyStore
+
+	// runCtx is cancelled by Shutdown once the drain timeout has passed.
+	runCtx     context.Context
+	cancelRuns context.CancelFunc
+	runMu      sync.Mutex
+	running    sync.WaitGroup
+	draining   bool
 }
 
-func NewTaskManager(cfg *internal.Config) *TaskManager {
-	return &TaskManager{
+func NewTaskManager(cfg *internal.Config, store storage.ArchiveStore) (*TaskManager, error) {
+	ids, err := NewIDGenerator(cfg.TaskIDFormat)
+	if err != nil {
+		return nil, err
+	}
+	m := &TaskManager{
 		tasks:      make(map[string]*internal.Task),
 		activeJobs: make(chan struct{}, cfg.MaxTasks),
-		cfg:        cfg,
+		store:      store,
+		links:      NewLinkSigner(cfg.DownloadSecret, strings.TrimSuffix(cfg.PublicURL, "/")),
+		ids:        ids,
+		events:     NewEventBus(),
+		index:      newSearchIndex(),
+		sched:      scheduler{schedules: make(map[string]*Schedule)},
+		groups:     groupStore{groups: make(map[string]*Group)},
+	}
+	m.cfg.Store(cfg)
+	m.runCtx, m.cancelRuns = context.WithCancel(context.Background())
+	if err := m.loadSchedules(); err != nil {
+		return nil, err
+	}
+	if err := m.loadKeys(); err != nil {
+		return nil, fmt.Errorf("load api keys: %w", err)
+	}
+	if err := m.loadState(deadLettersFile, &m.webhooks.dead); err != nil {
+		return nil, fmt.Errorf("load dead letters: %w", err)
+	}
+	if err := m.restoreTasks(); err != nil {
+		return nil, fmt.Errorf("restore tasks: %w", err)
 	}
+	if err := m.restoreGroups(); err != nil {
+		return nil, fmt.Errorf("restore groups: %w", err)
+	}
+	return m, nil
+}
+
+// config returns the current config. Read it once per operation; a reload
+// swaps in a new one.
+func (m *TaskManager) config() *internal.Config {
+	return m.cfg.Load()
+}
+
+// Reload applies the reloadable settings of cfg and returns the changed
+// settings that only take effect after a restart. Tasks keep the settings
+// they were created with.
+func (m *TaskManager) Reload(cfg *internal.Config) []string {
+	merged, ignored := m.config().Reload(cfg)
+	m.cfg.Store(merged)
+	m.keys.setConfigKeys(merged.Auth.Keys)
+	return ignored
 }
 
-func (m *TaskManager) CreateTask() (*internal.Task, error) {
+func (m *TaskManager) ValidID(id string) bool {
+	return m.ids.Valid(id)
+}
+
+func (m *TaskManager) CreateTask(opts TaskOptions) (*internal.Task, error) {
+	if m.Draining() {
+		return nil, ErrShuttingDown
+	}
+	cfg := m.config()
+	if opts.Policy == "" {
+		opts.Policy = cfg.Policy
+	}
+	policy, err := internal.ParseFailurePolicy(opts.Policy)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := normalizeLabels(opts.Labels)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := cfg.Settings(opts.Profile, opts.Overrides)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateFiles(&settings, nil, opts.Files); err != nil {
+		return nil, err
+	}
+	if err := validateMetadata(opts.Metadata); err != nil {
+		return nil, err
+	}
+	if opts.Callback != "" && !validCallback(opts.Callback) {
+		return nil, ErrInvalidCallback
+	}
+
+	if cfg.Disk.WhenFull != internal.WhenFullQueue {
+		if err := m.hasSpace(); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	if err := m.keys.admit(opts.Owner, settings.Profile, cfg.Auth.Enabled, now); err != nil {
+		return nil, err
+	}
 	select {
 	case m.activeJobs <- struct{}{}:
 	default:
+		m.keys.cancel(opts.Owner, now)
 		return nil, ErrServerBusy
 	}
 
 	task := &internal.Task{
-		ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
-		Status:    internal.StatusPending,
-		CreatedAt: time.Now(),
+		Status:      internal.StatusPending,
+		CreatedAt:   now,
+		Policy:      policy,
+		Settings:    settings,
+		Stream:      opts.Stream,
+		LegalHold:   opts.LegalHold,
+		Labels:      labels,
+		Metadata:    maps.Clone(opts.Metadata),
+		Owner:       opts.Owner,
+		CreatedBy:   opts.CreatedBy,
+		CallbackURL: opts.Callback,
 	}
 
-	m.tasksMu.Lock()
-	m.tasks[task.ID] = task
-	m.tasksMu.Unlock()
+	if err := m.insertTask(task); err != nil {
+		<-m.activeJobs
+		m.keys.cancel(opts.Owner, now)
+		return nil, err
+	}
+	m.events.Publish(Event{Type: EventStatus, TaskID: task.ID, Status: task.Status})
+
+	if len(opts.Files) > 0 {
+		task.Mu.Lock()
+		task.Files = newFiles(opts.Files)
+		m.maybeStart(task, true)
+		task.Mu.Unlock()
+	}
+	m.reindex(task)
 
 	return task, nil
 }
 
-func (m *TaskManager) AddFile(taskID, url string) error {
-	m.tasksMu.RLock()
-	task, exists := m.tasks[taskID]
-	m.tasksMu.RUnlock()
+// insertTask assigns a fresh ID and registers the task, retrying on the
+// unlikely collision with an existing one.
+func (m *TaskManager) insertTask(task *internal.Task) error {
+	m.tasksMu.Lock()
+	defer m.tasksMu.Unlock()
 
-	if !exists {
-		return ErrTaskNotFound
+	for range 5 {
+		id := m.ids.NewID()
+		if _, exists := m.tasks[id]; !exists {
+			task.ID = id
+			m.tasks[id] = task
+			return nil
+		}
+	}
+	return ErrIDCollision
+}
+
+func normalizeLabels(labels []string) ([]string, error) {
+	var out []string
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label == "" || len(label) > 64 || strings.ContainsAny(label, ",") {
+			return nil, ErrInvalidLabel
+		}
+		if !slices.Contains(out, label) {
+			out = append(out, label)
+		}
 	}
+	return out, nil
+}
+
+// processTask must be registered with track before it starts.
+func (m *TaskManager) processTask(task *internal.Task) {
+	defer func() {
+		<-m.activeJobs
+		m.running.Done()
+	}()
+	ctx, cancel := context.WithTimeout(m.runCtx, task.Settings.PrTimeout)
+	defer cancel()
+
+	reserved, err := m.admit(ctx, task)
+	if m.requeue(task) {
+		m.release(reserved)
+		return
+	}
+	if err != nil {
+		task.Mu.Lock()
+		task.Error = err.Error()
+		task.Mu.Unlock()
+		m.finishTask(task, internal.StatusFailed, "", "")
+		return
+	}
+	defer m.release(reserved)
 
 	task.Mu.Lock()
-	defer task.Mu.Unlock()
+	m.setStatus(task, internal.StatusProcessing)
+	task.Mu.Unlock()
 
-	if len(task.Files) >= m.cfg.MaxFiles {
-		return ErrMaxFiles
+	// Scratch files live in a folder named after the task, so the janitor
+	// can tell them apart from leftovers while the task is unfinished.
+	cfg := m.config()
+	scratchDir := filepath.Join(cfg.TempDir, task.ID)
+	if err := os.MkdirAll(scratchDir, 0755); err != nil {
+		task.Mu.Lock()
+		task.Error = err.Error()
+		task.Mu.Unlock()
+		m.finishTask(task, internal.StatusFailed, "", "")
+		return
 	}
+	defer os.RemoveAll(scratchDir)
 
-	ext := filepath.Ext(url)
-	valid := false
-	for _, allowed := range m.cfg.AllowedExts {
-		if ext == allowed {
-			valid = true
-			break
-		}
+	urls, fileNames := taskSources(task)
+	scratchPath := filepath.Join(scratchDir, task.ID+".zip")
+
+	if !cfg.StageDownloads {
+		m.archiveDirect(ctx, task, urls, fileNames, scratchPath)
+		return
 	}
 
-	if !valid {
-		return ErrInvalidFileType
+	retry := RetryPolicy{Retries: task.Settings.Retries, Backoff: task.Settings.RetryBackoff}
+	downloadedPaths, errors := DownloadFiles(ctx, urls, scratchDir, task.Settings.DwnTimeout, retry, m.tracker(task))
+	defer removeTempFiles(task)
+	if m.requeue(task) {
+		return
 	}
 
-	task.Files = append(task.Files, internal.File{
-		URL:    url,
-		Status: "queued",
-	})
+	status := m.recordResults(task, downloadedPaths, errors)
+	if status == internal.StatusFailed {
+		m.finishTask(task, internal.StatusFailed, "", "")
+		return
+	}
 
-	if len(task.Files) == m.cfg.MaxFiles {
-		go m.processTask(task)
+	task.Mu.Lock()
+	filePaths := make([]string, len(task.Files))
+	for i, file := range task.Files {
+		filePaths[i] = file.Path
 	}
+	task.Mu.Unlock()
 
-	return nil
+	if err := CreateArchive(filePaths, fileNames, scratchPath, task.Settings.ZipMethod()); err != nil {
+		os.Remove(scratchPath)
+		m.finishTask(task, internal.StatusFailed, "", "")
+		return
+	}
+	m.storeArchive(ctx, task, status, scratchPath)
 }
 
-func (m *TaskManager) processTask(task *internal.Task) {
-	task.Mu.Lock()
-	task.Status = internal.StatusProcessing
-	task.Mu.Unlock()
+func (m *TaskManager) archiveDirect(ctx context.Context, task *internal.Task, urls, fileNames []string, scratchPath string) {
+	file, err := os.Create(scratchPath)
+	if err != nil {
+		m.finishTask(task, internal.StatusFailed, "", "")
+		return
+	}
 
-	defer func() {
-		<-m.activeJobs
-	}()
-	prt, err := m.cfg.MakeTimePr()
+	retry := RetryPolicy{Retries: task.Settings.Retries, Backoff: task.Settings.RetryBackoff}
+	errs, err := StreamArchive(ctx, file, urls, fileNames, task.Settings.ZipMethod(), retry, m.tracker(task))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if m.requeue(task) {
+		os.Remove(scratchPath)
+		return
+	}
+
+	status := m.recordResults(task, nil, errs)
+	if err != nil || status == internal.StatusFailed {
+		os.Remove(scratchPath)
+		m.finishTask(task, internal.StatusFailed, "", "")
+		return
+	}
+	m.storeArchive(ctx, task, status, scratchPath)
+}
+
+// storeArchive hashes a finished archive in scratch space, hands it over to
+// the archive store and records the final task status.
+func (m *TaskManager) storeArchive(ctx context.Context, task *internal.Task, status internal.TaskStatus, scratchPath string) {
+	key := archiveKey(task.Owner, task.ID+".zip")
+	info, err := os.Stat(scratchPath)
+	var hash string
+	if err == nil {
+		hash, err = HashFile(scratchPath)
+	}
+	if err == nil {
+		err = m.store.PutFile(ctx, key, scratchPath)
+	}
 	if err != nil {
-		log.Fatal(err)
+		os.Remove(scratchPath)
+		if m.requeue(task) {
+			return
+		}
+		log.Printf("Failed to store archive for task %s: %v", task.ID, err)
+		m.finishTask(task, internal.StatusFailed, "", "")
+		return
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), prt)
-	defer cancel()
 
-	urls := make([]string, len(task.Files))
+	task.Mu.Lock()
+	task.ArchiveSize = info.Size()
+	task.Mu.Unlock()
+	m.finishTask(task, status, key, hash)
+}
+
+func taskSources(task *internal.Task) (urls, fileNames []string) {
+	task.Mu.Lock()
+	defer task.Mu.Unlock()
+
+	urls = make([]string, len(task.Files))
+	fileNames = make([]string, len(task.Files))
 	for i, file := range task.Files {
 		urls[i] = file.URL
+		fileNames[i] = file.ArchiveName()
 	}
+	return urls, fileNames
+}
 
-	dwn, err := m.cfg.MakeTimeDwn()
-	downloadedPaths, errors := DownloadFiles(ctx, urls, m.cfg.TempDir, dwn)
-
+// recordResults stores per-file outcomes on the task and returns the status
+// its failure policy assigns. A nil paths slice means the files were archived
+// directly and every file without an error counts as fetched.
+func (m *TaskManager) recordResults(task *internal.Task, paths []string, errors []error) internal.TaskStatus {
 	task.Mu.Lock()
+	defer task.Mu.Unlock()
+
+	succeeded := 0
 	for i := range task.Files {
-		if i < len(errors) && errors[i] != nil {
+		var err error
+		if i < len(errors) {
+			err = errors[i]
+		}
+		if err != nil {
 			task.Files[i].Status = "failed"
-			task.Files[i].Error = errors[i].Error()
-		} else if i < len(downloadedPaths) && downloadedPaths[i] != "" {
+			task.Files[i].Error = err.Error()
+		} else if paths == nil {
+			task.Files[i].Status = "downloaded"
+			succeeded++
+		} else if i < len(paths) && paths[i] != "" {
 			task.Files[i].Status = "downloaded"
-			task.Files[i].Path = downloadedPaths[i]
+			task.Files[i].Path = paths[i]
+			succeeded++
 		}
+		m.publishFile(task, i)
 	}
+	return task.Policy.Outcome(succeeded, len(task.Files))
+}
+
+func (m *TaskManager) finishTask(task *internal.Task, status internal.TaskStatus, archiveKey, hash string) {
+	task.Mu.Lock()
+	task.ArchiveKey = archiveKey
+	task.ArchiveHash = hash
+	task.CompletedAt = time.Now()
+	m.setStatus(task, status)
+	received := task.Totals(task.CompletedAt).Received
 	task.Mu.Unlock()
+	m.keys.finished(task.Owner, received, task.CompletedAt)
 
-	archivePath := filepath.Join(m.cfg.ArchiveDir, task.ID+".zip")
-	filePaths := make([]string, len(task.Files))
-	fileNames := make([]string, len(task.Files))
-	for i, file := range task.Files {
-		filePaths[i] = file.Path
-		fileNames[i] = filepath.Base(file.URL)
+	// finishTask runs inside tracked work, so the drain counter is above
+	// zero and webhook deliveries may still join it during Shutdown.
+	m.running.Add(1)
+	go m.notify(task, WebhookEventFinished)
+	go m.taskFinishedForGroups(task.ID)
+}
+
+// DeleteTask removes a task that has not started yet and frees its server
+// slot and the concurrent task slot of its key. Subscribers see it fail with
+// "task deleted" and webhooks receive task.cancelled.
+func (m *TaskManager) DeleteTask(taskID string) error {
+	task, err := m.GetTask(taskID)
+	if err != nil {
+		return err
 	}
+
 	task.Mu.Lock()
-	if err := CreateArchive(filePaths, fileNames, archivePath); err == nil {
-		task.Status = internal.StatusCompleted
-		task.ArchivePath = archivePath
-	} else {
-		task.Status = internal.StatusFailed
+	if task.Status != internal.StatusPending {
+		task.Mu.Unlock()
+		return ErrTaskStarted
 	}
+	if !m.track() {
+		task.Mu.Unlock()
+		return ErrShuttingDown
+	}
+	// Failing the task first keeps AddFiles and StartStream off it.
+	task.Error = "task deleted"
 	task.CompletedAt = time.Now()
+	m.setStatus(task, internal.StatusFailed)
+	owner, deletedAt := task.Owner, task.CompletedAt
 	task.Mu.Unlock()
+
+	m.tasksMu.Lock()
+	delete(m.tasks, taskID)
+	m.tasksMu.Unlock()
+	m.index.remove(taskID)
+
+	<-m.activeJobs
+	m.keys.finished(owner, 0, deletedAt)
+	go m.notify(task, WebhookEventCancelled)
+	go m.taskFinishedForGroups(taskID)
+	return nil
 }
 
 func (m *TaskManager) GetTask(taskID string) (*internal.Task, error) {
@@ -157,3 +469,64 @@ func (m *TaskManager) GetTask(taskID string) (*internal.Task, error) {
 	}
 	return task, nil
 }
+
+// Profiles returns the settings of every configured profile before task
+// overrides and the name of the default one.
+func (m *TaskManager) Profiles() (map[string]internal.TaskSettings, string) {
+	cfg := m.config()
+	profiles := make(map[string]internal.TaskSettings, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		if settings, err := cfg.Settings(name, internal.ProfileOverrides{}); err == nil {
+			profiles[name] = settings
+		}
+	}
+	return profiles, cfg.DefaultProfile
+}
+
+func (m *TaskManager) DownloadLink(taskID string, opts LinkOptions) (*DownloadLink, error) {
+	if _, err := m.GetTask(taskID); err != nil {
+		return nil, err
+	}
+	if opts.TTL <= 0 {
+		ttl, err := m.config().MakeTimeLink()
+		if err != nil {
+			return nil, err
+		}
+		opts.TTL = ttl
+	}
+	return m.links.Sign(taskID, opts), nil
+}
+
+func (m *TaskManager) VerifyDownloadLink(taskID string, q url.Values, clientIP string, consume bool) error {
+	if !IsSignedLink(q) {
+		if m.config().AllowUnsigned {
+			return nil
+		}
+		return ErrLinkInvalid
+	}
+	return m.links.Verify(taskID, q, clientIP, consume)
+}
+
+func (m *TaskManager) OpenArchive(ctx context.Context, key string) (*storage.Object, error) {
+	return m.store.Open(ctx, key)
+}
+
+// ArchiveRedirect returns a presigned object URL when the store supports it
+// and redirects are enabled, or an empty string when the archive should be
+// streamed through the server.
+func (m *TaskManager) ArchiveRedirect(key, filename string) string {
+	cfg := m.config()
+	if !cfg.Store.Redirect {
+		return ""
+	}
+	ttl, err := cfg.MakeTimeLink()
+	if err != nil {
+		return ""
+	}
+	u, err := m.store.PresignGet(key, filename, ttl)
+	if err != nil {
+		log.Printf("Failed to presign %s, serving it through the server: %v", key, err)
+		return ""
+	}
+	return u
+}
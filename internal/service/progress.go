@@ -0,0 +1,117 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"test_ex_zip/internal"
+	"time"
+)
+
+const progressInterval = 250 * time.Millisecond
+
+// Progress observes file transfers. Begin and End bracket an attempt to
+// fetch the file at index, Update reports the bytes received so far; total
+// is -1 when the origin sent no length.
+type Progress interface {
+	Begin(index int)
+	Update(index int, written, total int64)
+	End(index int, err error)
+}
+
+// progressWriter counts bytes passing through and reports them right away
+// and then at most once per progressInterval.
+type progressWriter struct {
+	w       io.Writer
+	index   int
+	written int64
+	total   int64
+	report  Progress
+	last    time.Time
+}
+
+func newProgressWriter(w io.Writer, index int, total int64, report Progress) *progressWriter {
+	p := &progressWriter{w: w, index: index, total: total, report: report, last: time.Now()}
+	if report != nil {
+		report.Update(index, 0, total)
+	}
+	return p
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.written += int64(n)
+	if p.report != nil && time.Since(p.last) >= progressInterval {
+		p.last = time.Now()
+		p.report.Update(p.index, p.written, p.total)
+	}
+	return n, err
+}
+
+func (p *progressWriter) done() {
+	if p.report != nil {
+		p.report.Update(p.index, p.written, p.total)
+	}
+}
+
+// fileTracker records transfer progress on the task's files and publishes
+// it as progress events.
+type fileTracker struct {
+	m    *TaskManager
+	task *internal.Task
+}
+
+func (m *TaskManager) tracker(task *internal.Task) Progress {
+	return &fileTracker{m: m, task: task}
+}
+
+func (t *fileTracker) Begin(i int) {
+	t.task.Mu.Lock()
+	defer t.task.Mu.Unlock()
+
+	file := &t.task.Files[i]
+	file.Status = "downloading"
+	file.Attempts++
+	file.Error = ""
+	file.Received = 0
+	file.StartedAt = time.Now()
+	file.FinishedAt = time.Time{}
+}
+
+func (t *fileTracker) Update(i int, written, total int64) {
+	t.task.Mu.Lock()
+	file := &t.task.Files[i]
+	file.HTTPStatus = http.StatusOK
+	file.Received = written
+	file.Total = total
+	file.Rate = rate(written, time.Since(file.StartedAt))
+	t.task.Mu.Unlock()
+
+	t.m.events.Publish(Event{Type: EventProgress, TaskID: t.task.ID, File: &i, Bytes: written, Total: total})
+}
+
+func (t *fileTracker) End(i int, err error) {
+	t.task.Mu.Lock()
+	defer t.task.Mu.Unlock()
+
+	file := &t.task.Files[i]
+	file.Status = "downloaded"
+	if err != nil {
+		file.Status = "failed"
+		file.Error = err.Error()
+	}
+	file.FinishedAt = time.Now()
+	file.Rate = rate(file.Received, file.FinishedAt.Sub(file.StartedAt))
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		file.HTTPStatus = statusErr.Code
+	}
+}
+
+func rate(bytes int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(bytes) / elapsed.Seconds())
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"sync"
+	"test_ex_zip/internal"
+	"time"
+)
+
+var ErrInsufficientStorage = errors.New("insufficient storage")
+
+type diskQuota struct {
+	mu       sync.Mutex
+	reserved int64
+}
+
+// admit reserves disk space for a task before it starts downloading. When
+// the space is not there it evicts old archives and, depending on
+// Disk.WhenFull, either fails right away or keeps the task queued until
+// space frees up or ctx expires.
+func (m *TaskManager) admit(ctx context.Context, task *internal.Task) (int64, error) {
+	need := m.estimate(ctx, task)
+	for {
+		err := m.reserve(ctx, need)
+		if err == nil || m.config().Disk.WhenFull != internal.WhenFullQueue {
+			return need, err
+		}
+
+		task.Mu.Lock()
+		m.setStatus(task, internal.StatusQueued)
+		task.Mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, err
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// estimate sums declared or preflighted file sizes. Staged downloads need
+// room for the files and the scratch archive at the same time.
+func (m *TaskManager) estimate(ctx context.Context, task *internal.Task) int64 {
+	task.Mu.Lock()
+	files := make([]internal.File, len(task.Files))
+	copy(files, task.Files)
+	task.Mu.Unlock()
+
+	cfg := m.config()
+	var total int64
+	for i, file := range files {
+		size := file.Size
+		if size <= 0 && cfg.Disk.Preflight {
+			// An unknown size stays unset rather than showing up as -1.
+			if size = probeSize(ctx, file.URL); size > 0 {
+				task.Mu.Lock()
+				task.Files[i].Size = size
+				task.Mu.Unlock()
+			}
+		}
+		total += max(size, 0)
+	}
+
+	if cfg.StageDownloads {
+		return 2 * total
+	}
+	return total
+}
+
+func (m *TaskManager) reserve(ctx context.Context, need int64) error {
+	m.disk.mu.Lock()
+	defer m.disk.mu.Unlock()
+
+	if err := m.checkSpace(need); err != nil {
+		if !m.evict(ctx, need) {
+			return err
+		}
+	}
+	m.disk.reserved += need
+	return nil
+}
+
+func (m *TaskManager) release(n int64) {
+	m.disk.mu.Lock()
+	m.disk.reserved -= n
+	m.disk.mu.Unlock()
+}
+
+// checkSpace must be called with disk.mu held.
+func (m *TaskManager) checkSpace(need int64) error {
+	cfg := m.config()
+	local := cfg.Store.Type == "" || cfg.Store.Type == "local"
+	if quota := cfg.Disk.QuotaBytes; quota > 0 {
+		// Measure the directories rather than trust the task records, so
+		// leftovers and orphaned archives count too.
+		used := dirUsage(cfg.TempDir)
+		if local {
+			used += dirUsage(cfg.ArchiveDir)
+		} else {
+			used += m.archivedBytes()
+		}
+		if used+m.disk.reserved+need > quota {
+			return ErrInsufficientStorage
+		}
+	}
+
+	dirs := []string{cfg.TempDir}
+	if local {
+		dirs = append(dirs, cfg.ArchiveDir)
+	}
+	for _, dir := range dirs {
+		free, ok := diskFree(dir)
+		if ok && free-m.disk.reserved-need < cfg.Disk.MinFreeBytes {
+			return ErrInsufficientStorage
+		}
+	}
+	return nil
+}
+
+func dirUsage(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+func (m *TaskManager) hasSpace() error {
+	m.disk.mu.Lock()
+	defer m.disk.mu.Unlock()
+	return m.checkSpace(0)
+}
+
+func (m *TaskManager) archivedBytes() int64 {
+	m.tasksMu.RLock()
+	defer m.tasksMu.RUnlock()
+
+	var total int64
+	for _, task := range m.tasks {
+		task.Mu.Lock()
+		total += task.ArchiveSize
+		task.Mu.Unlock()
+	}
+	return total + m.groupArchiveBytes()
+}
+
+// evict removes archives until need fits: expired ones first, then ones that
+// have already been downloaded, oldest first within each group. Archives
+// nobody has fetched yet and tasks under legal hold are never evicted.
+// Must be called with disk.mu held.
+func (m *TaskManager) evict(ctx context.Context, need int64) bool {
+	type candidate struct {
+		task    *internal.Task
+		expired bool
+		done    time.Time
+	}
+
+	now := time.Now()
+	var candidates []candidate
+	m.tasksMu.RLock()
+	for _, task := range m.tasks {
+		task.Mu.Lock()
+		expires := m.expiresAt(task)
+		expired := !expires.IsZero() && now.After(expires)
+		if task.ArchiveKey != "" && !task.LegalHold && (expired || !task.DownloadedAt.IsZero()) {
+			candidates = append(candidates, candidate{task: task, expired: expired, done: task.CompletedAt})
+		}
+		task.Mu.Unlock()
+	}
+	m.tasksMu.RUnlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].expired != candidates[j].expired {
+			return candidates[i].expired
+		}
+		return candidates[i].done.Before(candidates[j].done)
+	})
+
+	for _, c := range candidates {
+		if c.expired {
+			m.expireTask(ctx, c.task)
+		} else {
+			m.dropArchive(ctx, c.task)
+		}
+		if m.checkSpace(need) == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"test_ex_zip/internal"
+	"time"
+)
+
+// RunJanitor periodically removes expired tasks with their archives and
+// stale files left in TempDir. It returns when ctx is cancelled.
+func (m *TaskManager) RunJanitor(ctx context.Context) {
+	interval, _ := m.config().Retention.MakeTimeSweep()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.sweep(ctx)
+			// A reload may have changed the interval.
+			if next, _ := m.config().Retention.MakeTimeSweep(); next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
+		}
+	}
+}
+
+func (m *TaskManager) sweep(ctx context.Context) {
+	now := time.Now()
+
+	m.tasksMu.RLock()
+	var expired []*internal.Task
+	for _, task := range m.tasks {
+		task.Mu.Lock()
+		expires := m.expiresAt(task)
+		task.Mu.Unlock()
+		if !expires.IsZero() && now.After(expires) {
+			expired = append(expired, task)
+		}
+	}
+	m.tasksMu.RUnlock()
+
+	for _, task := range expired {
+		m.expireTask(ctx, task)
+	}
+
+	m.sweepGroups(ctx, now)
+	m.sweepTemp(now)
+	m.links.Prune(now)
+}
+
+func (m *TaskManager) expireTask(ctx context.Context, task *internal.Task) {
+	task.Mu.Lock()
+	if task.LegalHold {
+		task.Mu.Unlock()
+		return
+	}
+	key := task.ArchiveKey
+	task.Mu.Unlock()
+
+	if key != "" {
+		if err := m.store.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete archive of task %s: %v", task.ID, err)
+			return
+		}
+	}
+
+	m.tasksMu.Lock()
+	delete(m.tasks, task.ID)
+	m.tasksMu.Unlock()
+	m.index.remove(task.ID)
+}
+
+// dropArchive deletes the archive of a task but keeps its record, so the
+// status stays visible while downloads answer 404.
+func (m *TaskManager) dropArchive(ctx context.Context, task *internal.Task) {
+	task.Mu.Lock()
+	key := task.ArchiveKey
+	task.Mu.Unlock()
+
+	if err := m.store.Delete(ctx, key); err != nil {
+		log.Printf("Failed to evict archive of task %s: %v", task.ID, err)
+		return
+	}
+
+	task.Mu.Lock()
+	task.ArchiveKey = ""
+	task.ArchiveSize = 0
+	task.Mu.Unlock()
+}
+
+// sweepTemp removes scratch files that outlived TempMaxAge, e.g. downloads
+// of a task whose processing was interrupted. The scratch folders of
+// unfinished tasks and running merges are kept however old their files are.
+func (m *TaskManager) sweepTemp(now time.Time) {
+	cfg := m.config()
+	maxAge, err := cfg.Retention.MakeTimeTemp()
+	if err != nil || maxAge <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(cfg.TempDir)
+	if err != nil {
+		log.Printf("Failed to read temp dir: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < maxAge {
+			continue
+		}
+		if info.IsDir() && m.unfinished(entry.Name()) {
+			continue
+		}
+		os.RemoveAll(filepath.Join(cfg.TempDir, entry.Name()))
+	}
+}
+
+// unfinished reports whether a scratch folder belongs to a task that has not
+// finished or to a group whose merge is running.
+func (m *TaskManager) unfinished(name string) bool {
+	if id, ok := strings.CutPrefix(name, "group-"); ok {
+		m.groups.mu.Lock()
+		defer m.groups.mu.Unlock()
+		g, ok := m.groups.groups[id]
+		return ok && g.MergeStatus == MergeRunning
+	}
+
+	m.tasksMu.RLock()
+	task, ok := m.tasks[name]
+	m.tasksMu.RUnlock()
+	if !ok {
+		return false
+	}
+	task.Mu.Lock()
+	defer task.Mu.Unlock()
+	return !task.IsFinished()
+}
+
+func removeTempFiles(task *internal.Task) {
+	task.Mu.Lock()
+	defer task.Mu.Unlock()
+
+	for i := range task.Files {
+		if task.Files[i].Path != "" {
+			os.Remove(task.Files[i].Path)
+			task.Files[i].Path = ""
+		}
+	}
+}
+
+func (m *TaskManager) expiresAt(task *internal.Task) time.Time {
+	retention := m.config().Retention
+	for _, rule := range retention.Rules {
+		if slices.Contains(task.Labels, rule.Label) {
+			afterCompletion, _ := rule.MakeTimeCompletion()
+			afterDownload, _ := rule.MakeTimeDownload()
+			return task.ExpiresAt(afterCompletion, afterDownload)
+		}
+	}
+	afterCompletion, _ := retention.MakeTimeCompletion()
+	afterDownload, _ := retention.MakeTimeDownload()
+	return task.ExpiresAt(afterCompletion, afterDownload)
+}
+
+// ExpiresAt reports when the task will be cleaned up; zero means never.
+func (m *TaskManager) ExpiresAt(task *internal.Task) time.Time {
+	task.Mu.Lock()
+	defer task.Mu.Unlock()
+	return m.expiresAt(task)
+}
+
+func (m *TaskManager) MarkDownloaded(task *internal.Task) {
+	task.Mu.Lock()
+	if task.DownloadedAt.IsZero() {
+		task.DownloadedAt = time.Now()
+	}
+	task.Mu.Unlock()
+}
+
+func (m *TaskManager) SetLegalHold(taskID string, hold bool) error {
+	task, err := m.GetTask(taskID)
+	if err != nil {
+		return err
+	}
+	task.Mu.Lock()
+	task.LegalHold = hold
+	task.Mu.Unlock()
+	return nil
+}
@@ -0,0 +1,371 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"test_ex_zip/internal"
+	"time"
+)
+
+const (
+	schedulesFile   = "schedules.json"
+	scheduleHistory = 50
+
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+var (
+	ErrScheduleNotFound = errors.New("schedule not found")
+	ErrInvalidSchedule  = errors.New("invalid schedule")
+)
+
+// ScheduleTemplate holds the files and options of the tasks a schedule
+// creates.
+type ScheduleTemplate struct {
+	Files     []FileSpec                `json:"files"`
+	Policy    string                    `json:"policy,omitempty"`
+	Labels    []string                  `json:"labels,omitempty"`
+	Metadata  map[string]string         `json:"metadata,omitempty"`
+	Owner     string                    `json:"owner,omitempty"`
+	CreatedBy string                    `json:"created_by,omitempty"`
+	Callback  string                    `json:"callback_url,omitempty"`
+	LegalHold bool                      `json:"legal_hold,omitempty"`
+	Profile   string                    `json:"profile,omitempty"`
+	Overrides internal.ProfileOverrides `json:"overrides,omitzero"`
+}
+
+type ScheduleRun struct {
+	At      time.Time `json:"at"`
+	Trigger string    `json:"trigger"`
+	TaskID  string    `json:"task_id,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Schedule creates a task from Template on every Cron match, evaluated in
+// Timezone, or every Interval.
+type Schedule struct {
+	ID        string           `json:"id"`
+	Name      string           `json:"name,omitempty"`
+	Cron      string           `json:"cron,omitempty"`
+	Interval  string           `json:"interval,omitempty"`
+	Timezone  string           `json:"timezone,omitempty"`
+	Template  ScheduleTemplate `json:"template"`
+	Paused    bool             `json:"paused"`
+	CreatedAt time.Time        `json:"created_at"`
+	NextRun   time.Time        `json:"next_run,omitzero"`
+	History   []ScheduleRun    `json:"history"`
+
+	cron     *cronSpec
+	interval time.Duration
+	loc      *time.Location
+}
+
+type scheduler struct {
+	mu        sync.Mutex
+	schedules map[string]*Schedule
+}
+
+func (s *Schedule) compile() error {
+	if (s.Cron == "") == (s.Interval == "") {
+		return fmt.Errorf("%w: set either cron or interval", ErrInvalidSchedule)
+	}
+
+	s.loc = time.Local
+	if s.Timezone != "" {
+		loc, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return fmt.Errorf("%w: unknown timezone %q", ErrInvalidSchedule, s.Timezone)
+		}
+		s.loc = loc
+	}
+
+	if s.Cron != "" {
+		spec, err := parseCron(s.Cron)
+		if err != nil {
+			return err
+		}
+		s.cron = spec
+		return nil
+	}
+	interval, err := time.ParseDuration(s.Interval)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("%w: bad interval %q", ErrInvalidSchedule, s.Interval)
+	}
+	s.interval = interval
+	return nil
+}
+
+func (s *Schedule) nextAfter(t time.Time) time.Time {
+	if s.cron != nil {
+		return s.cron.next(t.In(s.loc))
+	}
+	return t.Add(s.interval)
+}
+
+func (s *Schedule) clone() Schedule {
+	c := *s
+	c.Template.Files = slices.Clone(s.Template.Files)
+	c.Template.Labels = slices.Clone(s.Template.Labels)
+	c.Template.Metadata = maps.Clone(s.Template.Metadata)
+	c.Template.Overrides.AllowedExts = slices.Clone(s.Template.Overrides.AllowedExts)
+	c.History = append([]ScheduleRun{}, s.History...)
+	return c
+}
+
+func (t *ScheduleTemplate) options() TaskOptions {
+	return TaskOptions{
+		Policy:    t.Policy,
+		LegalHold: t.LegalHold,
+		Labels:    slices.Clone(t.Labels),
+		Metadata:  maps.Clone(t.Metadata),
+		Owner:     t.Owner,
+		CreatedBy: t.CreatedBy,
+		Files:     slices.Clone(t.Files),
+		Callback:  t.Callback,
+		Profile:   t.Profile,
+		Overrides: t.Overrides,
+	}
+}
+
+// validateTemplate applies the checks CreateTask would, so a schedule cannot
+// be saved with a template that always fails.
+func (m *TaskManager) validateTemplate(t *ScheduleTemplate) error {
+	if len(t.Files) == 0 {
+		return fmt.Errorf("%w: template has no files", ErrInvalidSchedule)
+	}
+	cfg := m.config()
+	policy := t.Policy
+	if policy == "" {
+		policy = cfg.Policy
+	}
+	if _, err := internal.ParseFailurePolicy(policy); err != nil {
+		return err
+	}
+	if _, err := normalizeLabels(t.Labels); err != nil {
+		return err
+	}
+	if err := validateMetadata(t.Metadata); err != nil {
+		return err
+	}
+	if t.Callback != "" && !validCallback(t.Callback) {
+		return ErrInvalidCallback
+	}
+	settings, err := cfg.Settings(t.Profile, t.Overrides)
+	if err != nil {
+		return err
+	}
+	if cfg.Auth.Enabled && !m.keys.allowsProfile(t.Owner, settings.Profile) {
+		return ErrProfileDenied
+	}
+	return validateFiles(&settings, nil, t.Files)
+}
+
+func (m *TaskManager) CreateSchedule(s Schedule) (Schedule, error) {
+	if err := s.compile(); err != nil {
+		return Schedule{}, err
+	}
+	if err := m.validateTemplate(&s.Template); err != nil {
+		return Schedule{}, err
+	}
+
+	now := time.Now()
+	s.ID = m.ids.NewID()
+	s.CreatedAt = now
+	s.History = nil
+	s.NextRun = time.Time{}
+	if !s.Paused {
+		s.NextRun = s.nextAfter(now)
+	}
+
+	m.sched.mu.Lock()
+	defer m.sched.mu.Unlock()
+	m.sched.schedules[s.ID] = &s
+	m.saveSchedules()
+	return s.clone(), nil
+}
+
+func (m *TaskManager) ListSchedules(t Tenant) []Schedule {
+	m.sched.mu.Lock()
+	defer m.sched.mu.Unlock()
+
+	list := make([]Schedule, 0, len(m.sched.schedules))
+	for _, s := range m.sched.schedules {
+		if t.Owns(s.Template.Owner) {
+			list = append(list, s.clone())
+		}
+	}
+	slices.SortFunc(list, func(a, b Schedule) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return list
+}
+
+func (m *TaskManager) GetSchedule(id string) (Schedule, error) {
+	m.sched.mu.Lock()
+	defer m.sched.mu.Unlock()
+
+	s, ok := m.sched.schedules[id]
+	if !ok {
+		return Schedule{}, ErrScheduleNotFound
+	}
+	return s.clone(), nil
+}
+
+func (m *TaskManager) DeleteSchedule(id string) error {
+	m.sched.mu.Lock()
+	defer m.sched.mu.Unlock()
+
+	if _, ok := m.sched.schedules[id]; !ok {
+		return ErrScheduleNotFound
+	}
+	delete(m.sched.schedules, id)
+	m.saveSchedules()
+	return nil
+}
+
+// PauseSchedule stops or resumes a schedule. A resumed schedule runs at its
+// next due time after now.
+func (m *TaskManager) PauseSchedule(id string, paused bool) (Schedule, error) {
+	m.sched.mu.Lock()
+	defer m.sched.mu.Unlock()
+
+	s, ok := m.sched.schedules[id]
+	if !ok {
+		return Schedule{}, ErrScheduleNotFound
+	}
+	if s.Paused != paused {
+		s.Paused = paused
+		s.NextRun = time.Time{}
+		if !paused {
+			s.NextRun = s.nextAfter(time.Now())
+		}
+		m.saveSchedules()
+	}
+	return s.clone(), nil
+}
+
+// TriggerSchedule creates a task from the schedule right away, also when it
+// is paused. The regular timetable is not affected. An error from creating
+// the task is returned along with the recorded run.
+func (m *TaskManager) TriggerSchedule(id string) (ScheduleRun, error) {
+	m.sched.mu.Lock()
+	s, ok := m.sched.schedules[id]
+	var template ScheduleTemplate
+	if ok {
+		template = s.clone().Template
+	}
+	m.sched.mu.Unlock()
+	if !ok {
+		return ScheduleRun{}, ErrScheduleNotFound
+	}
+
+	run, err := m.runTemplate(&template, TriggerManual)
+	m.recordRun(id, run, time.Time{})
+	return run, err
+}
+
+// RunScheduler creates tasks for due schedules until ctx is cancelled.
+func (m *TaskManager) RunScheduler(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.runDue(now)
+		}
+	}
+}
+
+func (m *TaskManager) runDue(now time.Time) {
+	type due struct {
+		id       string
+		template ScheduleTemplate
+		next     time.Time
+	}
+
+	m.sched.mu.Lock()
+	var runs []due
+	for _, s := range m.sched.schedules {
+		if s.Paused || s.NextRun.IsZero() || s.NextRun.After(now) {
+			continue
+		}
+		runs = append(runs, due{id: s.ID, template: s.clone().Template, next: s.nextAfter(now)})
+	}
+	m.sched.mu.Unlock()
+
+	for _, d := range runs {
+		run, _ := m.runTemplate(&d.template, TriggerSchedule)
+		m.recordRun(d.id, run, d.next)
+	}
+}
+
+func (m *TaskManager) runTemplate(template *ScheduleTemplate, trigger string) (ScheduleRun, error) {
+	run := ScheduleRun{At: time.Now(), Trigger: trigger}
+	task, err := m.CreateTask(template.options())
+	if err != nil {
+		run.Error = err.Error()
+		return run, err
+	}
+	run.TaskID = task.ID
+	return run, nil
+}
+
+// recordRun appends run to the schedule history and, when next is set,
+// moves the schedule to its next due time.
+func (m *TaskManager) recordRun(id string, run ScheduleRun, next time.Time) {
+	m.sched.mu.Lock()
+	defer m.sched.mu.Unlock()
+
+	s, ok := m.sched.schedules[id]
+	if !ok {
+		return
+	}
+	s.History = append(s.History, run)
+	if len(s.History) > scheduleHistory {
+		s.History = slices.Delete(s.History, 0, len(s.History)-scheduleHistory)
+	}
+	if !next.IsZero() && !s.Paused {
+		s.NextRun = next
+	}
+	if run.Error != "" {
+		log.Printf("Schedule %s failed to create a task: %s", id, run.Error)
+	}
+	m.saveSchedules()
+}
+
+// saveSchedules must be called with sched.mu held.
+func (m *TaskManager) saveSchedules() {
+	list := make([]*Schedule, 0, len(m.sched.schedules))
+	for _, s := range m.sched.schedules {
+		list = append(list, s)
+	}
+	if err := m.saveState(schedulesFile, list); err != nil {
+		log.Printf("Failed to save schedules: %v", err)
+	}
+}
+
+// loadSchedules restores persisted schedules. Due times missed while the
+// server was down collapse into a single run on the next tick.
+func (m *TaskManager) loadSchedules() error {
+	var list []*Schedule
+	if err := m.loadState(schedulesFile, &list); err != nil {
+		return fmt.Errorf("load schedules: %w", err)
+	}
+
+	m.sched.mu.Lock()
+	defer m.sched.mu.Unlock()
+	for _, s := range list {
+		if err := s.compile(); err != nil {
+			log.Printf("Skipping schedule %s: %v", s.ID, err)
+			continue
+		}
+		m.sched.schedules[s.ID] = s
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"errors"
+	"maps"
+	"strings"
+	"sync"
+	"test_ex_zip/internal"
+	"unicode"
+)
+
+const (
+	maxMetadataEntries = 32
+	maxMetadataKey     = 64
+	maxMetadataValue   = 256
+)
+
+var ErrInvalidMetadata = errors.New("invalid metadata")
+
+// TaskUpdate changes the searchable attributes of a task. Nil Labels keeps
+// the current labels; a nil metadata value deletes that key.
+type TaskUpdate struct {
+	Labels   *[]string
+	Metadata map[string]*string
+}
+
+// searchIndex maps lowercased tokens of labels, metadata, URLs and file
+// names to the IDs of the tasks containing them.
+type searchIndex struct {
+	mu     sync.RWMutex
+	tokens map[string]map[string]struct{}
+	byTask map[string][]string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		tokens: make(map[string]map[string]struct{}),
+		byTask: make(map[string][]string),
+	}
+}
+
+// taskTokens must be called with task.Mu held.
+func taskTokens(task *internal.Task) []string {
+	var texts []string
+	texts = append(texts, task.Labels...)
+	for key, value := range task.Metadata {
+		texts = append(texts, key, value)
+	}
+	for _, file := range task.Files {
+		texts = append(texts, file.URL, file.ArchiveName())
+	}
+
+	seen := make(map[string]struct{})
+	var tokens []string
+	for _, text := range texts {
+		for _, token := range tokenize(text) {
+			if _, ok := seen[token]; !ok {
+				seen[token] = struct{}{}
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func (ix *searchIndex) put(taskID string, tokens []string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.removeLocked(taskID)
+	for _, token := range tokens {
+		ids, ok := ix.tokens[token]
+		if !ok {
+			ids = make(map[string]struct{})
+			ix.tokens[token] = ids
+		}
+		ids[taskID] = struct{}{}
+	}
+	ix.byTask[taskID] = tokens
+}
+
+func (ix *searchIndex) remove(taskID string) {
+	ix.mu.Lock()
+	ix.removeLocked(taskID)
+	ix.mu.Unlock()
+}
+
+func (ix *searchIndex) removeLocked(taskID string) {
+	for _, token := range ix.byTask[taskID] {
+		delete(ix.tokens[token], taskID)
+		if len(ix.tokens[token]) == 0 {
+			delete(ix.tokens, token)
+		}
+	}
+	delete(ix.byTask, taskID)
+}
+
+// search returns the IDs of tasks that contain every word of the query,
+// each word matching a token or a token prefix.
+func (ix *searchIndex) search(query string) map[string]struct{} {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	var result map[string]struct{}
+	for _, word := range tokenize(query) {
+		matched := make(map[string]struct{})
+		for token, ids := range ix.tokens {
+			if strings.HasPrefix(token, word) {
+				maps.Copy(matched, ids)
+			}
+		}
+		if result != nil {
+			maps.DeleteFunc(result, func(id string, _ struct{}) bool {
+				_, ok := matched[id]
+				return !ok
+			})
+		} else {
+			result = matched
+		}
+		if len(result) == 0 {
+			break
+		}
+	}
+	if result == nil {
+		result = make(map[string]struct{})
+	}
+	return result
+}
+
+func (m *TaskManager) reindex(task *internal.Task) {
+	task.Mu.Lock()
+	tokens := taskTokens(task)
+	task.Mu.Unlock()
+	m.index.put(task.ID, tokens)
+}
+
+// UpdateTask replaces the labels and merges the metadata of a task.
+func (m *TaskManager) UpdateTask(taskID string, update TaskUpdate) (*internal.Task, error) {
+	task, err := m.GetTask(taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	var labels []string
+	if update.Labels != nil {
+		if labels, err = normalizeLabels(*update.Labels); err != nil {
+			return nil, err
+		}
+	}
+
+	task.Mu.Lock()
+	metadata := maps.Clone(task.Metadata)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	for key, value := range update.Metadata {
+		if value == nil {
+			delete(metadata, key)
+		} else {
+			metadata[key] = *value
+		}
+	}
+	if err := validateMetadata(metadata); err != nil {
+		task.Mu.Unlock()
+		return nil, err
+	}
+	if update.Labels != nil {
+		task.Labels = labels
+	}
+	task.Metadata = metadata
+	if len(metadata) == 0 {
+		task.Metadata = nil
+	}
+	task.Mu.Unlock()
+
+	m.reindex(task)
+	return task, nil
+}
+
+func validateMetadata(metadata map[string]string) error {
+	if len(metadata) > maxMetadataEntries {
+		return ErrInvalidMetadata
+	}
+	for key, value := range metadata {
+		if key == "" || len(key) > maxMetadataKey || len(value) > maxMetadataValue {
+			return ErrInvalidMetadata
+		}
+		for _, r := range key {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_.-", r) {
+				return ErrInvalidMetadata
+			}
+		}
+	}
+	return nil
+}
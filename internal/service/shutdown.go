@@ -0,0 +1,159 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"test_ex_zip/internal"
+	"time"
+)
+
+const tasksFile = "tasks.json"
+
+var ErrShuttingDown = errors.New("server shutting down")
+
+// Draining reports whether Shutdown has started. New work is refused from
+// then on.
+func (m *TaskManager) Draining() bool {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	return m.draining
+}
+
+// DrainTimeout is how long Shutdown waits for running tasks.
+func (m *TaskManager) DrainTimeout() time.Duration {
+	drain, _ := m.config().MakeTimeDrain()
+	return drain
+}
+
+// track registers a unit of background work with Shutdown. It reports false
+// once the manager is draining, and the work must not start.
+func (m *TaskManager) track() bool {
+	m.runMu.Lock()
+	defer m.runMu.Unlock()
+	if m.draining {
+		return false
+	}
+	m.running.Add(1)
+	return true
+}
+
+// Shutdown stops accepting work and waits up to drain_timeout for running
+// tasks and webhook deliveries. Tasks still running after that are cancelled
+// and put back in the queue, pending deliveries go to the dead letters. Tasks,
+// groups and dead letters are then saved to state_dir for the next start, and
+// event subscribers are disconnected.
+func (m *TaskManager) Shutdown() {
+	m.runMu.Lock()
+	m.draining = true
+	m.runMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(done)
+	}()
+
+	drain := m.DrainTimeout()
+	select {
+	case <-done:
+	case <-time.After(drain):
+		log.Printf("Drain timeout of %s reached, cancelling running tasks", drain)
+		m.cancelRuns()
+		<-done
+	}
+	m.cancelRuns()
+
+	if err := m.saveTasks(); err != nil {
+		log.Printf("Failed to save tasks: %v", err)
+	}
+	if err := m.saveGroups(); err != nil {
+		log.Printf("Failed to save groups: %v", err)
+	}
+	if err := m.saveKeys(); err != nil {
+		log.Printf("Failed to save api keys: %v", err)
+	}
+	if err := m.saveState(deadLettersFile, m.DeadLetters()); err != nil {
+		log.Printf("Failed to save dead letters: %v", err)
+	}
+	m.events.Close()
+}
+
+// requeue puts a task cancelled by Shutdown back in the queue so the next
+// start runs it again. It reports false when the server is not shutting down.
+func (m *TaskManager) requeue(task *internal.Task) bool {
+	if m.runCtx.Err() == nil {
+		return false
+	}
+
+	task.Mu.Lock()
+	defer task.Mu.Unlock()
+	for i := range task.Files {
+		file := &task.Files[i]
+		*file = internal.File{URL: file.URL, Name: file.Name, Size: file.Size, Status: "queued", Path: file.Path}
+	}
+	task.Error = ""
+	m.setStatus(task, internal.StatusQueued)
+	return true
+}
+
+func (m *TaskManager) saveTasks() error {
+	m.tasksMu.RLock()
+	defer m.tasksMu.RUnlock()
+
+	list := make([]json.RawMessage, 0, len(m.tasks))
+	for _, task := range m.tasks {
+		task.Mu.Lock()
+		data, err := json.Marshal(task)
+		task.Mu.Unlock()
+		if err != nil {
+			return err
+		}
+		list = append(list, data)
+	}
+	return m.saveState(tasksFile, list)
+}
+
+// restoreTasks loads the tasks saved by the last Shutdown. Unfinished tasks
+// take their server slot back and queued ones start again. The file is
+// removed afterwards so a crash later cannot bring back stale tasks.
+func (m *TaskManager) restoreTasks() error {
+	var list []*internal.Task
+	if err := m.loadState(tasksFile, &list); err != nil {
+		return err
+	}
+
+	for _, task := range list {
+		m.tasks[task.ID] = task
+		m.reindex(task)
+		if task.IsFinished() {
+			continue
+		}
+
+		select {
+		case m.activeJobs <- struct{}{}:
+		default:
+			task.Error = ErrServerBusy.Error()
+			task.Status = internal.StatusFailed
+			task.CompletedAt = time.Now()
+			continue
+		}
+		m.keys.restored(task.Owner)
+		if task.Status != internal.StatusPending {
+			task.Status = internal.StatusQueued
+			m.running.Add(1)
+			go m.processTask(task)
+		}
+	}
+
+	if len(list) > 0 {
+		log.Printf("Restored %d tasks", len(list))
+	}
+	err := os.Remove(filepath.Join(m.config().StateDir, tasksFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"test_ex_zip/internal"
+)
+
+var (
+	ErrNotStreamTask  = errors.New("task is not in streaming mode")
+	ErrStreamNotReady = errors.New("stream not ready")
+	ErrStreamFailed   = errors.New("task failed under its failure policy")
+)
+
+// StartStream claims a pending streaming task for a single client. The task
+// keeps its server slot until StreamTask returns. claim runs once the task is
+// known to be streamable; its error is returned and leaves the task pending.
+func (m *TaskManager) StartStream(taskID string, claim func() error) (*internal.Task, error) {
+	task, err := m.GetTask(taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	task.Mu.Lock()
+	defer task.Mu.Unlock()
+
+	if !task.Stream {
+		return nil, ErrNotStreamTask
+	}
+	if task.Status != internal.StatusPending || len(task.Files) == 0 {
+		return nil, ErrStreamNotReady
+	}
+	if !m.track() {
+		return nil, ErrShuttingDown
+	}
+	if err := claim(); err != nil {
+		m.running.Done()
+		return nil, err
+	}
+	m.setStatus(task, internal.StatusProcessing)
+	return task, nil
+}
+
+func (m *TaskManager) StreamTask(ctx context.Context, task *internal.Task, w io.Writer) error {
+	defer func() {
+		<-m.activeJobs
+		m.running.Done()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, task.Settings.PrTimeout)
+	defer cancel()
+	stop := context.AfterFunc(m.runCtx, cancel)
+	defer stop()
+
+	urls, fileNames := taskSources(task)
+	retry := RetryPolicy{Retries: task.Settings.Retries, Backoff: task.Settings.RetryBackoff}
+	errs, err := StreamArchive(ctx, w, urls, fileNames, task.Settings.ZipMethod(), retry, m.tracker(task))
+
+	status := m.recordResults(task, nil, errs)
+	if err != nil {
+		status = internal.StatusFailed
+	} else if status == internal.StatusFailed {
+		// The zip is already well formed but lacks files the policy requires.
+		err = ErrStreamFailed
+	}
+	m.finishTask(task, status, "", "")
+	return err
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"test_ex_zip/internal"
+)
+
+// Tenant confines a caller to the tasks, schedules and groups of one owner.
+// AllTenants, used for admin keys and while auth is disabled, sees
+// everything.
+type Tenant string
+
+const AllTenants Tenant = ""
+
+func (t Tenant) Owns(owner string) bool {
+	return t == AllTenants || string(t) == owner
+}
+
+// GetTaskFor is GetTask limited to the tasks of t. Tasks of other tenants
+// are reported as missing, so their IDs cannot be probed.
+func (m *TaskManager) GetTaskFor(t Tenant, taskID string) (*internal.Task, error) {
+	task, err := m.GetTask(taskID)
+	if err != nil || !t.Owns(task.Owner) {
+		return nil, ErrTaskNotFound
+	}
+	return task, nil
+}
+
+func (m *TaskManager) GetScheduleFor(t Tenant, id string) (Schedule, error) {
+	s, err := m.GetSchedule(id)
+	if err != nil || !t.Owns(s.Template.Owner) {
+		return Schedule{}, ErrScheduleNotFound
+	}
+	return s, nil
+}
+
+func (m *TaskManager) GetGroupFor(t Tenant, id string) (Group, GroupStatus, error) {
+	g, st, err := m.GetGroup(id)
+	if err != nil || !t.Owns(g.Owner) {
+		return Group{}, GroupStatus{}, ErrGroupNotFound
+	}
+	return g, st, nil
+}
+
+// archiveKey stores the archives of an owner in a folder of its own. Owners
+// that are not safe path segments share the top level.
+func archiveKey(owner, name string) string {
+	if owner != "" && internal.ValidKeyID(owner) {
+		return owner + "/" + name
+	}
+	return name
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"math"
+	"slices"
+	"test_ex_zip/internal"
+	"time"
+)
+
+// WaitTask blocks until the task reaches one of the until statuses or
+// finishes, or, when until is empty, until its status or a file outcome
+// changes. It gives up after wait, capped by max_status_wait, and reports
+// whether the condition was met. Changes arrive through the event bus, so
+// task.Mu is only taken to check the current state.
+func (m *TaskManager) WaitTask(ctx context.Context, task *internal.Task, until []internal.TaskStatus, wait time.Duration) bool {
+	maxWait, err := m.config().MakeTimeMaxWait()
+	if err != nil {
+		return false
+	}
+	wait = min(wait, maxWait)
+	if wait <= 0 {
+		return false
+	}
+
+	sub, _, last, _ := m.events.Subscribe(task.ID, math.MaxUint64)
+	defer func() { sub.Cancel() }()
+
+	if waitDone(task, until) {
+		return true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return false
+		case e, ok := <-sub.C:
+			if ok {
+				last = e.ID
+				if waitMatches(e, until) {
+					return true
+				}
+				continue
+			}
+			// The bus dropped a subscriber that fell behind, or is closing
+			// for shutdown. Only the task itself can say whether the
+			// condition was met.
+			if waitDone(task, until) {
+				return true
+			}
+			if m.events.Closed() {
+				return false
+			}
+			var backlog []Event
+			sub, backlog, _, _ = m.events.Subscribe(task.ID, last)
+			for _, e := range backlog {
+				last = e.ID
+				if waitMatches(e, until) {
+					return true
+				}
+			}
+		}
+	}
+}
+
+func waitDone(task *internal.Task, until []internal.TaskStatus) bool {
+	task.Mu.Lock()
+	defer task.Mu.Unlock()
+	return task.IsFinished() || slices.Contains(until, task.Status)
+}
+
+func waitMatches(e Event, until []internal.TaskStatus) bool {
+	if e.Terminal() {
+		return true
+	}
+	if len(until) == 0 {
+		return e.Type == EventStatus || e.Type == EventFile
+	}
+	return e.Type == EventStatus && slices.Contains(until, e.Status)
+}
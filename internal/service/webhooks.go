@@ -0,0 +1,229 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"test_ex_zip/internal"
+	"time"
+)
+
+const deadLettersFile = "dead_letters.json"
+
+const (
+	WebhookEventFinished  = "task.finished"
+	WebhookEventCancelled = "task.cancelled"
+
+	WebhookIDHeader        = "X-Webhook-Id"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+type WebhookPayload struct {
+	Event       string              `json:"event"`
+	TaskID      string              `json:"task_id"`
+	Status      internal.TaskStatus `json:"status"`
+	Error       string              `json:"error,omitempty"`
+	Files       []internal.File     `json:"files"`
+	Totals      internal.TaskTotals `json:"totals"`
+	Archive     string              `json:"archive,omitempty"`
+	ArchiveHash string              `json:"archive_sha256,omitempty"`
+	ArchiveSize int64               `json:"archive_size,omitempty"`
+	Labels      []string            `json:"labels,omitempty"`
+	Metadata    map[string]string   `json:"metadata,omitempty"`
+	CreatedAt   time.Time           `json:"created_at"`
+	CompletedAt time.Time           `json:"completed_at"`
+}
+
+// DeadLetter is a webhook delivery that ran out of attempts or was refused
+// by the receiver.
+type DeadLetter struct {
+	ID        string          `json:"id"`
+	TaskID    string          `json:"task_id"`
+	URL       string          `json:"url"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error"`
+	FailedAt  time.Time       `json:"failed_at"`
+}
+
+type webhookSender struct {
+	mu   sync.Mutex
+	dead []DeadLetter
+}
+
+func validCallback(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// notify posts the final state of the task as event to its callback URL and
+// to the global webhook, if any. The caller registers it with the drain;
+// deliveries still retrying when the drain times out are dead-lettered.
+func (m *TaskManager) notify(task *internal.Task, event string) {
+	defer m.running.Done()
+
+	targets := make([]string, 0, 2)
+	if hook := m.config().Webhook.URL; hook != "" {
+		targets = append(targets, hook)
+	}
+	task.Mu.Lock()
+	if task.CallbackURL != "" && !slices.Contains(targets, task.CallbackURL) {
+		targets = append(targets, task.CallbackURL)
+	}
+	task.Mu.Unlock()
+	if len(targets) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(m.webhookPayload(task, event))
+	if err != nil {
+		log.Printf("Failed to encode webhook for task %s: %v", task.ID, err)
+		return
+	}
+	for _, target := range targets {
+		m.running.Add(1)
+		go func() {
+			defer m.running.Done()
+			m.deliverWebhook(m.runCtx, task.ID, target, body)
+		}()
+	}
+}
+
+func (m *TaskManager) webhookPayload(task *internal.Task, event string) WebhookPayload {
+	task.Mu.Lock()
+	payload := WebhookPayload{
+		Event:       event,
+		TaskID:      task.ID,
+		Status:      task.Status,
+		Error:       task.Error,
+		Files:       slices.Clone(task.Files),
+		Totals:      task.Totals(time.Now()),
+		ArchiveHash: task.ArchiveHash,
+		ArchiveSize: task.ArchiveSize,
+		Labels:      slices.Clone(task.Labels),
+		Metadata:    maps.Clone(task.Metadata),
+		CreatedAt:   task.CreatedAt,
+		CompletedAt: task.CompletedAt,
+	}
+	hasArchive := task.HasArchive()
+	task.Mu.Unlock()
+
+	if hasArchive {
+		if link, err := m.DownloadLink(task.ID, LinkOptions{}); err == nil {
+			payload.Archive = link.URL
+		}
+	}
+	return payload
+}
+
+// deliverWebhook retries with exponential backoff on network errors, 5xx,
+// 408 and 429. Other 4xx answers and exhausted attempts end in the
+// dead-letter list.
+func (m *TaskManager) deliverWebhook(ctx context.Context, taskID, target string, body []byte) {
+	cfg := m.config().Webhook
+	backoff, _ := cfg.MakeTimeBackoff()
+	maxBackoff, _ := cfg.MakeTimeMaxBackoff()
+	timeout, _ := cfg.MakeTimeTimeout()
+	client := &http.Client{Timeout: timeout}
+	deliveryID := RandomIDs{}.NewID()
+
+	attempts := max(cfg.MaxAttempts, 1)
+	attempt := 1
+	var lastErr error
+deliver:
+	for ; ; attempt++ {
+		retry, err := m.postWebhook(ctx, client, deliveryID, target, body)
+		if err == nil {
+			return
+		}
+		lastErr = err
+		if !retry || attempt == attempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			lastErr = fmt.Errorf("%w, last attempt: %v", ErrShuttingDown, lastErr)
+			break deliver
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+
+	log.Printf("Webhook for task %s to %s failed after %d attempts: %v", taskID, target, attempt, lastErr)
+	m.webhooks.bury(DeadLetter{
+		ID:        deliveryID,
+		TaskID:    taskID,
+		URL:       target,
+		Payload:   body,
+		Attempts:  attempt,
+		LastError: lastErr.Error(),
+		FailedAt:  time.Now(),
+	}, cfg.DeadLetterLimit)
+}
+
+func (m *TaskManager) postWebhook(ctx context.Context, client *http.Client, deliveryID, target string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, deliveryID)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	if secret := m.config().Webhook.Secret; secret != "" {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(secret, timestamp, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("status %d", resp.StatusCode)
+	}
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of "timestamp.body".
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *webhookSender) bury(d DeadLetter, limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dead = append(s.dead, d)
+	if limit > 0 && len(s.dead) > limit {
+		s.dead = slices.Delete(s.dead, 0, len(s.dead)-limit)
+	}
+}
+
+// DeadLetters returns failed webhook deliveries, oldest first.
+func (m *TaskManager) DeadLetters() []DeadLetter {
+	m.webhooks.mu.Lock()
+	defer m.webhooks.mu.Unlock()
+	return append([]DeadLetter{}, m.webhooks.dead...)
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"test_ex_zip/internal"
+	"time"
+)
+
+var (
+	ErrNotExist           = errors.New("archive does not exist")
+	ErrPresignUnsupported = errors.New("store does not support presigned URLs")
+)
+
+type ArchiveStore interface {
+	// PutFile moves a finished archive from local scratch space into the store.
+	PutFile(ctx context.Context, key, path string) error
+	Open(ctx context.Context, key string) (*Object, error)
+	Delete(ctx context.Context, key string) error
+	PresignGet(key, filename string, ttl time.Duration) (string, error)
+}
+
+type Object struct {
+	io.ReadSeekCloser
+	Size    int64
+	ModTime time.Time
+}
+
+func NewArchiveStore(cfg *internal.Config) (ArchiveStore, error) {
+	switch cfg.Store.Type {
+	case "", "local":
+		return NewLocalStore(cfg.ArchiveDir), nil
+	case "s3":
+		return NewS3Store(cfg.Store)
+	}
+	return nil, fmt.Errorf("unknown archive store type %q", cfg.Store.Type)
+}
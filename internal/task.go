@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"path"
 	"sync"
 	"time"
 )
@@ -9,24 +10,131 @@ type TaskStatus string
 
 const (
 	StatusPending    TaskStatus = "pending"
+	StatusQueued     TaskStatus = "queued"
 	StatusProcessing TaskStatus = "processing"
 	StatusCompleted  TaskStatus = "completed"
+	StatusPartial    TaskStatus = "partial"
 	StatusFailed     TaskStatus = "failed"
 )
 
 type File struct {
-	URL    string `json:"url"`
-	Status string `json:"status"`
-	Error  string `json:"error,omitempty"`
-	Path   string `json:"-"`
+	URL        string    `json:"url"`
+	Name       string    `json:"name,omitempty"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	Received   int64     `json:"bytes_received"`
+	Total      int64     `json:"bytes_total,omitempty"`
+	Rate       int64     `json:"bytes_per_second,omitempty"`
+	HTTPStatus int       `json:"http_status,omitempty"`
+	Attempts   int       `json:"attempts,omitempty"`
+	StartedAt  time.Time `json:"started_at,omitzero"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	Path       string    `json:"-"`
+}
+
+// TaskTotals sums up the transfers of a task. Total only counts files whose
+// size is known.
+type TaskTotals struct {
+	Files      int   `json:"files"`
+	Downloaded int   `json:"downloaded"`
+	Failed     int   `json:"failed"`
+	Received   int64 `json:"bytes_received"`
+	Total      int64 `json:"bytes_total"`
+	Rate       int64 `json:"bytes_per_second"`
 }
 
 type Task struct {
-	ID          string     `json:"id"`
-	Status      TaskStatus `json:"status"`
-	Files       []File     `json:"files"`
-	CreatedAt   time.Time  `json:"created_at"`
-	CompletedAt time.Time  `json:"completed_at,omitempty"`
-	ArchivePath string     `json:"archive_path,omitempty"`
-	Mu          sync.Mutex `json:"-"`
+	ID           string            `json:"id"`
+	Status       TaskStatus        `json:"status"`
+	Files        []File            `json:"files"`
+	CreatedAt    time.Time         `json:"created_at"`
+	CompletedAt  time.Time         `json:"completed_at,omitempty"`
+	ArchiveKey   string            `json:"archive_key,omitempty"`
+	ArchiveHash  string            `json:"archive_hash,omitempty"`
+	ArchiveSize  int64             `json:"archive_size,omitempty"`
+	Error        string            `json:"error,omitempty"`
+	Policy       FailurePolicy     `json:"policy"`
+	Settings     TaskSettings      `json:"settings"`
+	Stream       bool              `json:"stream,omitempty"`
+	LegalHold    bool              `json:"legal_hold,omitempty"`
+	Labels       []string          `json:"labels,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	Owner        string            `json:"owner,omitempty"`
+	CreatedBy    string            `json:"created_by,omitempty"`
+	CallbackURL  string            `json:"callback_url,omitempty"`
+	DownloadedAt time.Time         `json:"downloaded_at,omitempty"`
+	Mu           sync.Mutex        `json:"-"`
+}
+
+func (f *File) ArchiveName() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return path.Base(f.URL)
+}
+
+// Totals must be called with Mu held. Rate is measured from the first file
+// start to the last file end, or to now while files are still transferring.
+func (t *Task) Totals(now time.Time) TaskTotals {
+	totals := TaskTotals{Files: len(t.Files)}
+	var start, end time.Time
+	running := false
+	for _, file := range t.Files {
+		switch file.Status {
+		case "downloaded":
+			totals.Downloaded++
+		case "failed":
+			totals.Failed++
+		}
+		totals.Received += file.Received
+		if file.Total > 0 {
+			totals.Total += file.Total
+		}
+		if file.StartedAt.IsZero() {
+			continue
+		}
+		if start.IsZero() || file.StartedAt.Before(start) {
+			start = file.StartedAt
+		}
+		if file.FinishedAt.IsZero() {
+			running = true
+		} else if file.FinishedAt.After(end) {
+			end = file.FinishedAt
+		}
+	}
+	if running {
+		end = now
+	}
+	if !start.IsZero() && end.After(start) {
+		totals.Rate = int64(float64(totals.Received) / end.Sub(start).Seconds())
+	}
+	return totals
+}
+
+func (t *Task) HasArchive() bool {
+	return t.ArchiveKey != "" && (t.Status == StatusCompleted || t.Status == StatusPartial)
+}
+
+func (t *Task) IsFinished() bool {
+	return t.Status == StatusCompleted || t.Status == StatusPartial || t.Status == StatusFailed
+}
+
+// ExpiresAt returns when the task becomes eligible for cleanup, or the zero
+// time if it never expires. A zero duration disables the matching rule.
+func (t *Task) ExpiresAt(afterCompletion, afterDownload time.Duration) time.Time {
+	if t.LegalHold || !t.IsFinished() {
+		return time.Time{}
+	}
+
+	var expires time.Time
+	if afterCompletion > 0 {
+		expires = t.CompletedAt.Add(afterCompletion)
+	}
+	if afterDownload > 0 && !t.DownloadedAt.IsZero() {
+		if at := t.DownloadedAt.Add(afterDownload); expires.IsZero() || at.Before(expires) {
+			expires = at
+		}
+	}
+	return expires
 }
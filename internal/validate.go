@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ValidationError lists every problem found in a config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) check(ok bool, format string, args ...any) {
+	if !ok {
+		v.problems = append(v.problems, fmt.Sprintf(format, args...))
+	}
+}
+
+// duration accepts an empty value, which selects the default.
+func (v *validator) duration(field, value string, allowZero bool) {
+	if value == "" {
+		return
+	}
+	d, err := time.ParseDuration(value)
+	switch {
+	case err != nil:
+		v.problems = append(v.problems, fmt.Sprintf("%s: %q is not a duration like \"30s\" or \"5m\"", field, value))
+	case d < 0 || (d == 0 && !allowZero):
+		v.problems = append(v.problems, fmt.Sprintf("%s: must be positive, got %q", field, value))
+	}
+}
+
+func (v *validator) httpURL(field, value string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	v.check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		"%s: %q is not an http(s) URL", field, value)
+}
+
+func validExt(ext string) bool {
+	return len(ext) > 1 && ext[0] == '.' && !strings.ContainsAny(ext[1:], "./\\")
+}
+
+// validate collects every problem instead of stopping at the first one.
+// problems carries those found earlier, e.g. in environment overrides.
+func (c *Config) validate(problems []string) error {
+	v := &validator{problems: problems}
+
+	v.check(c.Addr != "", "server_address: must not be empty")
+	v.check(c.MaxTasks > 0, "max_tasks: must be positive, got %d", c.MaxTasks)
+	v.check(c.MaxFiles > 0, "max_files: must be positive, got %d", c.MaxFiles)
+	v.duration("processing_timeout", c.PrTimeout, false)
+	v.duration("download_timeout", c.DwnTimeout, false)
+	v.check(c.TempDir != "", "temp_dir: must not be empty")
+	v.check(c.ArchiveDir != "", "archive_dir: must not be empty")
+	v.check(c.StateDir != "", "state_dir: must not be empty")
+
+	v.check(len(c.AllowedExts) > 0, "allowed_exts: must not be empty")
+	for _, ext := range c.AllowedExts {
+		v.check(validExt(ext), "allowed_exts: %q must look like \".pdf\"", ext)
+	}
+	_, err := ParseFailurePolicy(c.Policy)
+	v.check(err == nil, "failure_policy: unknown policy %q", c.Policy)
+	switch c.TaskIDFormat {
+	case "", "random", "uuidv7":
+	default:
+		v.check(false, "task_id_format: must be \"random\" or \"uuidv7\", got %q", c.TaskIDFormat)
+	}
+
+	switch c.Store.Type {
+	case "", "local":
+	case "s3":
+		v.check(c.Store.Bucket != "", "archive_store.bucket: required for s3")
+		v.check(c.Store.Endpoint != "", "archive_store.endpoint: required for s3")
+		v.httpURL("archive_store.endpoint", c.Store.Endpoint)
+	default:
+		v.check(false, "archive_store.type: must be \"local\" or \"s3\", got %q", c.Store.Type)
+	}
+
+	v.duration("retention.after_completion", c.Retention.AfterCompletion, true)
+	v.duration("retention.after_download", c.Retention.AfterDownload, true)
+	v.duration("retention.temp_max_age", c.Retention.TempMaxAge, true)
+	v.duration("retention.sweep_interval", c.Retention.SweepInterval, false)
+	for i, rule := range c.Retention.Rules {
+		field := fmt.Sprintf("retention.rules[%d]", i)
+		v.check(rule.Label != "", "%s.label: must not be empty", field)
+		v.duration(field+".after_completion", rule.AfterCompletion, true)
+		v.duration(field+".after_download", rule.AfterDownload, true)
+	}
+
+	v.check(c.Disk.QuotaBytes >= 0, "disk.quota_bytes: must not be negative")
+	v.check(c.Disk.MinFreeBytes >= 0, "disk.min_free_bytes: must not be negative")
+	switch c.Disk.WhenFull {
+	case "", WhenFullRefuse, WhenFullQueue:
+	default:
+		v.check(false, "disk.when_full: must be %q or %q, got %q", WhenFullRefuse, WhenFullQueue, c.Disk.WhenFull)
+	}
+
+	v.httpURL("public_url", c.PublicURL)
+	v.duration("download_link_ttl", c.LinkTTL, false)
+	v.duration("idempotency_ttl", c.IdempotencyTTL, false)
+	v.duration("max_status_wait", c.MaxStatusWait, true)
+	v.duration("drain_timeout", c.DrainTimeout, true)
+
+	v.httpURL("webhook.url", c.Webhook.URL)
+	v.check(c.Webhook.MaxAttempts > 0, "webhook.max_attempts: must be positive, got %d", c.Webhook.MaxAttempts)
+	v.check(c.Webhook.DeadLetterLimit >= 0, "webhook.dead_letter_limit: must not be negative")
+	v.duration("webhook.backoff", c.Webhook.Backoff, false)
+	v.duration("webhook.max_backoff", c.Webhook.MaxBackoff, false)
+	v.duration("webhook.timeout", c.Webhook.Timeout, false)
+
+	v.duration("http.read_header_timeout", c.HTTP.ReadHeaderTimeout, false)
+	v.duration("http.read_timeout", c.HTTP.ReadTimeout, true)
+	v.duration("http.write_timeout", c.HTTP.WriteTimeout, true)
+	v.duration("http.idle_timeout", c.HTTP.IdleTimeout, true)
+	v.check(c.HTTP.MaxHeaderBytes > 0, "http.max_header_bytes: must be positive, got %d", c.HTTP.MaxHeaderBytes)
+	v.check(c.HTTP.MaxBodyBytes > 0, "http.max_body_bytes: must be positive, got %d", c.HTTP.MaxBodyBytes)
+	tls := c.HTTP.TLS
+	v.check((tls.CertFile == "") == (tls.KeyFile == ""), "http.tls: cert_file and key_file must be set together")
+	v.check(tls.ClientCAFile == "" || tls.Enabled(), "http.tls.client_ca_file: requires cert_file and key_file")
+	switch tls.ClientAuth {
+	case "", ClientAuthRequire, ClientAuthOptional:
+		v.check(tls.ClientAuth == "" || tls.ClientCAFile != "", "http.tls.client_auth: requires client_ca_file")
+	default:
+		v.check(false, "http.tls.client_auth: must be %q or %q, got %q", ClientAuthRequire, ClientAuthOptional, tls.ClientAuth)
+	}
+
+	for _, rl := range []struct {
+		name string
+		l    RateLimit
+	}{{"create", c.RateLimit.Create}, {"add", c.RateLimit.Add}, {"download", c.RateLimit.Download}} {
+		field, l := "rate_limit."+rl.name, rl.l
+		v.check(l.Requests >= 0, "%s.requests: must not be negative", field)
+		v.check(l.Burst >= 0, "%s.burst: must not be negative", field)
+		v.duration(field+".period", l.Period, false)
+	}
+
+	c.validateProfiles(v)
+	c.validateKeys(v)
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+func (c *Config) validateKeys(v *validator) {
+	ids := make(map[string]bool)
+	secrets := make(map[string]bool)
+	for i, key := range c.Auth.Keys {
+		field := fmt.Sprintf("auth.keys[%d]", i)
+		v.check(ValidKeyID(key.ID), "%s.id: %q must be 1-64 letters, digits, '.', '-' or '_'", field, key.ID)
+		v.check(!ids[key.ID], "%s.id: duplicate id %q", field, key.ID)
+		v.check(len(key.Key) >= MinKeyLength, "%s.key: must be at least %d characters", field, MinKeyLength)
+		v.check(!secrets[key.Key], "%s.key: same key as another entry", field)
+		ids[key.ID], secrets[key.Key] = true, true
+		c.validateKeyLimits(v, field+".limits", key.Limits)
+	}
+}
+
+func (c *Config) validateKeyLimits(v *validator, field string, l KeyLimits) {
+	v.check(l.ConcurrentTasks >= 0, "%s.concurrent_tasks: must not be negative", field)
+	v.check(l.TasksPerDay >= 0, "%s.tasks_per_day: must not be negative", field)
+	v.check(l.BytesPerDay >= 0, "%s.bytes_per_day: must not be negative", field)
+	for _, name := range l.Profiles {
+		_, ok := c.Profiles[name]
+		v.check(ok, "%s.profiles: unknown profile %q", field, name)
+	}
+}
+
+// ValidateKeyLimits checks the limits of a key created at runtime.
+func (c *Config) ValidateKeyLimits(l KeyLimits) error {
+	v := &validator{}
+	c.validateKeyLimits(v, "limits", l)
+	if len(v.problems) > 0 {
+		return errors.New(strings.Join(v.problems, "; "))
+	}
+	return nil
+}
@@ -1,42 +1,167 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"test_ex_zip/cli"
 	"test_ex_zip/internal"
 	"test_ex_zip/internal/handler"
+	"test_ex_zip/internal/ratelimit"
 	"test_ex_zip/internal/service"
+	"test_ex_zip/internal/storage"
 )
 
 func main() {
+	defaultConfig := "configs/conf.json"
+	if path := os.Getenv(internal.EnvPrefix + "CONFIG"); path != "" {
+		defaultConfig = path
+	}
 	guiMode := flag.Bool("gui", false, "Enable graphical user interface")
+	configPath := flag.String("config", defaultConfig, "Path to the JSON or YAML config file")
 	flag.Parse()
 
-	cfg, err := internal.LoadConfig("configs/conf.json")
+	cfg, err := internal.LoadConfig(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
-	manager := service.NewTaskManager(cfg)
+	store, err := storage.NewArchiveStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to init archive store: %v", err)
+	}
+	manager, err := service.NewTaskManager(cfg, store)
+	if err != nil {
+		log.Fatalf("Failed to init task manager: %v", err)
+	}
 	taskHandler := handler.NewTaskHandler(manager)
 
+	idemTTL, err := cfg.MakeTimeIdempotency()
+	if err != nil {
+		log.Fatalf("Invalid idempotency_ttl: %v", err)
+	}
+	idem := handler.NewIdempotency(idemTTL)
+
+	limits, err := handler.NewRateLimiter(cfg.RateLimit, ratelimit.NewMemoryStore())
+	if err != nil {
+		log.Fatalf("Invalid rate_limit: %v", err)
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /tasks", taskHandler.CreateTask)
-	mux.HandleFunc("POST /tasks/{id}", taskHandler.AddFile)
+	mux.HandleFunc("POST /tasks", limits.Wrap(handler.BucketCreate, idem.Wrap(taskHandler.CreateTask)))
+	mux.HandleFunc("GET /tasks", taskHandler.ListTasks)
+	mux.HandleFunc("GET /tasks/search", taskHandler.SearchTasks)
+	mux.HandleFunc("PATCH /tasks/{id}", taskHandler.UpdateTask)
+	mux.HandleFunc("DELETE /tasks/{id}", taskHandler.DeleteTask)
+	mux.HandleFunc("POST /tasks/{id}", limits.Wrap(handler.BucketAdd, idem.Wrap(taskHandler.AddFile)))
+	mux.HandleFunc("POST /tasks/{id}/files", limits.Wrap(handler.BucketAdd, idem.Wrap(taskHandler.AddFiles)))
+	mux.HandleFunc("POST /tasks/{id}/links", idem.Wrap(taskHandler.CreateLink))
+	mux.HandleFunc("PUT /tasks/{id}/hold", taskHandler.SetLegalHold)
+	mux.HandleFunc("GET /tasks/{id}/events", taskHandler.TaskEvents)
+	mux.HandleFunc("GET /events", taskHandler.Events)
+	mux.HandleFunc("GET /profiles", taskHandler.ListProfiles)
+	mux.HandleFunc("GET /webhooks/dead-letters", taskHandler.DeadLetters)
+	mux.HandleFunc("POST /schedules", idem.Wrap(taskHandler.CreateSchedule))
+	mux.HandleFunc("GET /schedules", taskHandler.ListSchedules)
+	mux.HandleFunc("GET /schedules/{id}", taskHandler.GetSchedule)
+	mux.HandleFunc("DELETE /schedules/{id}", taskHandler.DeleteSchedule)
+	mux.HandleFunc("POST /schedules/{id}/pause", taskHandler.PauseSchedule)
+	mux.HandleFunc("POST /schedules/{id}/resume", taskHandler.ResumeSchedule)
+	mux.HandleFunc("POST /schedules/{id}/run", limits.Wrap(handler.BucketCreate, idem.Wrap(taskHandler.RunSchedule)))
+	mux.HandleFunc("POST /groups", idem.Wrap(taskHandler.CreateGroup))
+	mux.HandleFunc("GET /groups/{id}", taskHandler.GetGroup)
+	mux.HandleFunc("DELETE /groups/{id}", taskHandler.DeleteGroup)
+	mux.HandleFunc("POST /groups/{id}/archive", idem.Wrap(taskHandler.MergeGroup))
+	mux.HandleFunc("GET /groups/{id}/download", limits.Wrap(handler.BucketDownload, taskHandler.DownloadGroup))
+	mux.HandleFunc("POST /keys", taskHandler.CreateKey)
+	mux.HandleFunc("GET /keys", taskHandler.ListKeys)
+	mux.HandleFunc("DELETE /keys/{id}", taskHandler.DeleteKey)
 	mux.HandleFunc("GET /status/{id}", taskHandler.GetStatus)
-	mux.HandleFunc("GET /download/{id}", taskHandler.DownloadArchive)
+	mux.HandleFunc("GET /download/{id}", limits.Wrap(handler.BucketDownload, taskHandler.DownloadArchive))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
+	go manager.RunJanitor(ctx)
+	go manager.RunScheduler(ctx)
+	go reloadOnHangup(*configPath, manager)
+
+	srv, err := handler.NewServer(cfg, taskHandler.Authenticate(taskHandler.Drain(mux)))
+	if err != nil {
+		log.Fatalf("Failed to init HTTP server: %v", err)
+	}
+	// Bind before the GUI starts, so its first requests do not race the listener.
+	ln, err := handler.Listen(srv)
+	if err != nil {
+		log.Fatal(err)
+	}
 	go func() {
-		log.Printf("Server started on %s", cfg.Addr)
-		log.Fatal(http.ListenAndServe(cfg.Addr, mux))
+		if srv.TLSConfig != nil {
+			log.Printf("Server started on %s with TLS", cfg.Addr)
+		} else {
+			log.Printf("Server started on %s", cfg.Addr)
+		}
+		if err := handler.Serve(srv, ln); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
 	}()
 
+	guiDone := make(chan struct{})
 	if *guiMode {
-		if err := cli.StartGUI(); err != nil {
-			log.Fatalf("GUI error: %v", err)
-		}
+		go func() {
+			defer close(guiDone)
+			if err := cli.StartGUI(ctx); err != nil {
+				log.Printf("GUI error: %v", err)
+			}
+			stop()
+		}()
 	} else {
-		select {}
+		close(guiDone)
+	}
+
+	<-ctx.Done()
+	// A second signal kills the process right away.
+	stop()
+	<-guiDone
+	shutdown(srv, manager)
+}
+
+// shutdown lets running tasks finish, answering new writes with 503, and
+// only then closes the HTTP server. Open connections get another
+// drain_timeout before they are cut.
+func shutdown(srv *http.Server, manager *service.TaskManager) {
+	log.Printf("Shutting down, draining running tasks")
+	manager.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), manager.DrainTimeout())
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Closing open connections: %v", err)
+		srv.Close()
+	}
+	log.Printf("Server stopped")
+}
+
+// reloadOnHangup re-reads the config on SIGHUP. An invalid config is logged
+// and the running one is kept.
+func reloadOnHangup(path string, manager *service.TaskManager) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		cfg, err := internal.LoadConfig(path)
+		if err != nil {
+			log.Printf("Config reload failed, keeping the current config: %v", err)
+			continue
+		}
+		if ignored := manager.Reload(cfg); len(ignored) > 0 {
+			log.Printf("Config reloaded; restart to apply %s", strings.Join(ignored, ", "))
+		} else {
+			log.Printf("Config reloaded")
+		}
 	}
 }